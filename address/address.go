@@ -0,0 +1,81 @@
+package address
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Messer4/base58check"
+)
+
+var (
+	// prefixes of the base58check encodings of addresses, by the prefix they encode to
+	prefixes = map[string][]byte{
+		"tz1": {6, 161, 159},
+		"tz2": {6, 161, 161},
+		"tz3": {6, 161, 164},
+		"KT1": {2, 90, 121},
+	}
+)
+
+const (
+	// size is the length of an encoded address
+	size = 36
+	// hashSize is the size of the public key or contract hash an address encodes
+	hashSize = 20
+)
+
+// Check returns an error if address is not a base58check encoded tz1, tz2, tz3 or KT1 address
+func Check(address string) error {
+	_, err := Decode(address)
+	return err
+}
+
+// Decode returns the public key hash or contract hash a tz1, tz2, tz3 or KT1 address encodes, or an
+// error if it is not one, checking the encoded prefix matches the one the address starts with
+func Decode(address string) ([]byte, error) {
+	if len(address) < 3 {
+		return nil, fmt.Errorf("invalid address '%s': must start with tz1, tz2, tz3 or KT1", address)
+	}
+	prefix, ok := prefixes[address[:3]]
+	if !ok {
+		return nil, fmt.Errorf("invalid address '%s': must start with tz1, tz2, tz3 or KT1", address)
+	}
+	if len(address) != size {
+		return nil, fmt.Errorf("invalid address '%s': must be %d characters, not %d", address, size, len(address))
+	}
+
+	data, err := base58check.Decode(address)
+	if err != nil && strings.Contains(err.Error(), "alphabet") {
+		return nil, fmt.Errorf("invalid address '%s': not base58, check it for typos", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid address '%s': checksum does not match, check it for typos", address)
+	}
+	if !bytes.HasPrefix(data, prefix) || len(data) != len(prefix)+hashSize {
+		return nil, fmt.Errorf("invalid address '%s': not a %s address", address, address[:3])
+	}
+	return data[len(prefix):], nil
+}
+
+// Line returns the line of a json document that the string value first appears on, or 0 if it does not,
+// to point errors at the address in a file that caused them
+func Line(data []byte, value string) int {
+	i := bytes.Index(data, []byte(strconv.Quote(value)))
+	if i < 0 {
+		return 0
+	}
+	return bytes.Count(data[:i], []byte("\n")) + 1
+}
+
+// CheckJSON checks an address read from a json document, and points the error at the line it is on
+func CheckJSON(data []byte, address string) error {
+	if err := Check(address); err != nil {
+		if line := Line(data, address); line > 0 {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		return err
+	}
+	return nil
+}
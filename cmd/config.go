@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/DefinitelyNotAGoat/payman/options"
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+func newConfigCommand() *cobra.Command {
+	var config = &cobra.Command{
+		Use:   "config",
+		Short: "config works with payman config files",
+	}
+
+	config.AddCommand(newValidateCommand())
+	return config
+}
+
+func newValidateCommand() *cobra.Command {
+	var validate = &cobra.Command{
+		Use:       "validate [payout|report]",
+		Short:     "validate checks the config file and environment as payout (default) or report would before running",
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{"payout", "report"},
+		Run: func(cmd *cobra.Command, args []string) {
+			command := "payout"
+			if len(args) > 0 {
+				command = args[0]
+			}
+
+			var conf options.Options
+			flags := pflag.NewFlagSet(command, pflag.ContinueOnError)
+			switch command {
+			case "payout":
+				payoutFlags(flags, &conf)
+			case "report":
+				reportFlags(flags, &conf)
+			default:
+				fmt.Printf("[config][validate] error: unknown command %s, validate payout or report (e.g. payman config validate payout)\n", command)
+				os.Exit(1)
+			}
+
+			file, _ := cmd.Flags().GetString("config")
+			confs, unknown, err := readOptions(flags, file, &conf)
+			if err != nil {
+				fmt.Printf("[config][validate] error: %v\n", err)
+				os.Exit(1)
+			}
+
+			errors := []string{}
+			warnings := []string{}
+			for _, key := range unknown {
+				warnings = append(warnings, fmt.Sprintf("[config][validate] warning: %s is not an option of payman %s", key, command))
+			}
+
+			if command == "payout" {
+				payoutErrors, payoutWarnings := payoutPreflight(confs)
+				errors = append(errors, payoutErrors...)
+				warnings = append(warnings, payoutWarnings...)
+			} else {
+				errors = append(errors, reportPreflight(confs)...)
+			}
+
+			for i := range confs {
+				if err = confs[i].ReadSecrets(); command == "payout" && err != nil {
+					errors = append(errors, fmt.Sprintf("[config][validate] error: %v", err))
+				}
+			}
+
+			printPreflight(errors, warnings)
+			fmt.Printf("[config][validate] config is valid for payman %s\n", command)
+		},
+	}
+
+	return validate
+}
+
+// loadOptions reads the config file and environment into the command's options, and returns the
+// options of every delegate to pay out, or exits if they cannot be read
+func loadOptions(cmd *cobra.Command, conf *options.Options) []options.Options {
+	file, _ := cmd.Flags().GetString("config")
+	confs, _, err := readOptions(cmd.Flags(), file, conf)
+	if err != nil {
+		fmt.Printf("[payout][preflight] error: %v\n", err)
+		os.Exit(1)
+	}
+	return confs
+}
+
+// readOptions reads the config file and PAYMAN_* environment variables into every flag in flags that
+// was not passed on the command line, then reads in the delegates listed, and returns the options of
+// every delegate and the keys in the config file that are not flags. Keys are named after flags, so
+// --cycle-from is cycle-from in the config file and PAYMAN_CYCLE_FROM in the environment. Delegates
+// can be listed in the config file under delegates, in the format of a delegates file.
+func readOptions(flags *pflag.FlagSet, file string, conf *options.Options) ([]options.Options, []string, error) {
+	v := viper.New()
+	v.SetEnvPrefix("payman")
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	v.AutomaticEnv()
+
+	if file == "" {
+		file = v.GetString("config")
+	}
+	if file != "" {
+		v.SetConfigFile(file)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, nil, fmt.Errorf("could not read config %s: %v", file, err)
+		}
+	}
+
+	unknown := []string{}
+	for _, key := range v.AllKeys() {
+		if flags.Lookup(key) == nil && key != "config" {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+
+	var err error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed || flag.Name == "config" || flag.Name == "help" || !v.IsSet(flag.Name) {
+			return
+		}
+
+		if _, inline := v.Get(flag.Name).([]interface{}); inline && flag.Name == "delegates" {
+			err = v.UnmarshalKey(flag.Name, &conf.Delegates.Delegates, func(c *mapstructure.DecoderConfig) { c.TagName = "json" })
+			if err == nil {
+				err = conf.Delegates.Check()
+			}
+			if err != nil {
+				err = fmt.Errorf("could not read in delegates from config %s: %v", file, err)
+			}
+			return
+		}
+
+		if setErr := flags.Set(flag.Name, v.GetString(flag.Name)); setErr != nil {
+			err = fmt.Errorf("invalid value '%s' for %s in config or environment: %v", v.GetString(flag.Name), flag.Name, setErr)
+		}
+	})
+	if err != nil {
+		return nil, unknown, err
+	}
+
+	// secrets in the config file are as exposed as the file itself
+	if file != "" && (v.InConfig("secret") || v.InConfig("password") || v.InConfig("delegates") && conf.Delegates.Secrets()) {
+		if err = options.CheckPrivate(file); err != nil {
+			return nil, unknown, fmt.Errorf("could not read config %s: it holds secret keys or passwords and %v", file, err)
+		}
+	}
+
+	if conf.Delegates.File != "" {
+		if err = conf.Delegates.ReadDelegates(); err != nil {
+			return nil, unknown, fmt.Errorf("could not read in delegates %s: %v", conf.Delegates.File, err)
+		}
+	}
+
+	confs, err := conf.ForDelegates()
+	return confs, unknown, err
+}
+
+// printPreflight prints the errors and warnings found before running a command, and exits if there are errors
+func printPreflight(errors, warnings []string) {
+	for _, err := range errors {
+		fmt.Println(err)
+	}
+	if len(errors) > 0 {
+		os.Exit(1)
+	}
+
+	for _, warning := range warnings {
+		fmt.Println(warning)
+	}
+}
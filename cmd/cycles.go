@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+
+	goTezos "github.com/DefinitelyNotAGoat/go-tezos"
+	"github.com/DefinitelyNotAGoat/payman/ledger"
+	"github.com/DefinitelyNotAGoat/payman/options"
+	pay "github.com/DefinitelyNotAGoat/payman/payer"
+)
+
+// payoutCycles returns the cycles to pay out for the delegate in conf, in order: the cycle passed, every
+// cycle from --cycle-from to --cycle-to, or with --backfill every cycle in that range the ledger does not
+// show as paid. Backfill starts at the first cycle paid in the ledger and ends at the latest payable
+// cycle unless told otherwise.
+func payoutCycles(gt *goTezos.GoTezos, book *ledger.Ledger, conf *options.Options) ([]int, error) {
+	if !conf.Backfill && conf.CycleFrom == 0 {
+		return []int{conf.Cycle}, nil
+	}
+
+	from, to := conf.CycleFrom, conf.CycleTo
+	if !conf.Backfill {
+		cycles := []int{}
+		for cycle := from; cycle <= to; cycle++ {
+			cycles = append(cycles, cycle)
+		}
+		return cycles, nil
+	}
+
+	if book == nil {
+		return nil, fmt.Errorf("could not backfill %s: no ledger", conf.Delegate)
+	}
+	if from == 0 {
+		from = book.FirstPaid(conf.Delegate)
+		if from == -1 {
+			return nil, fmt.Errorf("could not backfill %s: no payouts in the ledger, pass the first cycle to backfill (e.g. --cycle-from=95)", conf.Delegate)
+		}
+	}
+	if to == 0 {
+		var err error
+		to, err = pay.PayableCycle(gt, conf.CycleOffset)
+		if err != nil {
+			return nil, fmt.Errorf("could not backfill %s: %v", conf.Delegate, err)
+		}
+	}
+
+	return book.Unpaid(conf.Delegate, from, to), nil
+}
+
+// groupCycles splits cycles into the groups paid out together, a single group with --merge
+// or else a group for each cycle
+func groupCycles(cycles []int, merge bool) [][]int {
+	if merge && len(cycles) > 0 {
+		return [][]int{cycles}
+	}
+
+	groups := [][]int{}
+	for _, cycle := range cycles {
+		groups = append(groups, []int{cycle})
+	}
+	return groups
+}
+
+// cyclesPreflight checks the cycle range and backfill options, and returns an error for each problem found
+func cyclesPreflight(conf options.Options) []string {
+	errors := []string{}
+	ranged := conf.CycleFrom != 0 || conf.CycleTo != 0 || conf.Backfill
+
+	if conf.CycleFrom < 0 || conf.CycleTo < 0 {
+		errors = append(errors, "[payout][preflight] error: cycle range cannot be negative (e.g. --cycle-from=95 --cycle-to=98)")
+	}
+	if conf.Cycle != 0 && ranged {
+		errors = append(errors, "[payout][preflight] error: cannot pass a cycle with a cycle range or backfill (e.g. --cycle-from=95 --cycle-to=98)")
+	}
+	if !conf.Backfill && (conf.CycleFrom == 0) != (conf.CycleTo == 0) {
+		errors = append(errors, "[payout][preflight] error: pass both ends of the cycle range, or backfill (e.g. --cycle-from=95 --cycle-to=98)")
+	}
+	if conf.CycleTo != 0 && conf.CycleFrom > conf.CycleTo {
+		errors = append(errors, "[payout][preflight] error: cycle range ends before it starts (e.g. --cycle-from=95 --cycle-to=98)")
+	}
+	if conf.Service && ranged {
+		errors = append(errors, "[payout][preflight] error: cannot pass a cycle range or backfill while running as a service, it pays out every unpaid cycle")
+	}
+	if conf.Resume && (ranged || conf.Merge) {
+		errors = append(errors, "[payout][preflight] error: can only resume the payout of a single cycle (e.g. --cycle=95)")
+	}
+	if conf.PaymentsOverride.File != "" && (ranged || conf.Merge) {
+		errors = append(errors, "[payout][preflight] error: cannot override payments for a cycle range or backfill")
+	}
+
+	return errors
+}
@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	goTezos "github.com/DefinitelyNotAGoat/go-tezos"
+	"github.com/DefinitelyNotAGoat/payman/address"
+	"github.com/DefinitelyNotAGoat/payman/keystore"
+	"github.com/DefinitelyNotAGoat/payman/ledger"
+	"github.com/DefinitelyNotAGoat/payman/options"
+	pay "github.com/DefinitelyNotAGoat/payman/payer"
+	"github.com/DefinitelyNotAGoat/payman/reporting"
+	"github.com/DefinitelyNotAGoat/payman/signer"
+	"github.com/spf13/cobra"
+)
+
+func newPrepareCommand(conf *options.Options) *cobra.Command {
+	var source, publicKey string
+
+	var prepare = &cobra.Command{
+		Use:   "prepare <file>",
+		Short: "prepare forges a payout without signing it, and writes it to <file> to sign offline with payman sign",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+
+			confs := loadOptions(cmd, conf)
+			errors, warnings := paymentsPreflight(confs)
+			printPreflight(append(errors, preparePreflight(confs, source)...), warnings)
+
+			f, err := os.Create(conf.File)
+			if err != nil {
+				fmt.Printf("could not open logging file: %v\n", err)
+			}
+			defer f.Close()
+
+			log := log.New(f, "", log.Ldate|log.Ltime|log.Lshortfile)
+
+			reporter, err := reporting.NewReporter(log)
+			if err != nil {
+				reporter.Log(fmt.Sprintf("could not open file for reporting: %v\n", err))
+			}
+
+			gt, err := goTezos.NewGoTezos(conf.URL)
+			if err != nil {
+				reporter.Log(fmt.Sprintf("could not connect to network: %v\n", err))
+			}
+
+			book, err := ledger.Open(conf.Ledger)
+			if err != nil {
+				reporter.Log(fmt.Sprintf("could not open ledger: %v", err))
+				os.Exit(1)
+			}
+
+			c := &confs[0]
+			if c.PaymentsOverride.File != "" {
+				c.PaymentsOverride.Payments, err = c.PaymentsOverride.ReadPaymentsOverride()
+				if err != nil {
+					reporter.Log(fmt.Sprintf("could not parse payments override into payments: %v", err))
+					os.Exit(1)
+				}
+				reporting.PrintOverrideSummary(os.Stdout, c.PaymentsOverride)
+			}
+			if err = c.ReadFiles(); err != nil {
+				reporter.Log(err)
+				os.Exit(1)
+			}
+
+			address, key, err := sourceKey(c, source, publicKey)
+			if err != nil {
+				reporter.Log(err)
+				os.Exit(1)
+			}
+
+			cycles, err := payoutCycles(gt, book, c)
+			if err != nil {
+				reporter.Log(err)
+				os.Exit(1)
+			}
+
+			payer := pay.NewPayer(gt, nil, book, c)
+			payouts, prepared, err := payer.Prepare(cycles, address, key)
+			if err != nil {
+				reporter.Log(fmt.Sprintf("could not prepare payout for %s at cycles %v: %v", c.Delegate, cycles, err))
+				os.Exit(1)
+			}
+
+			reporter.PrintPaymentsTable(payouts)
+			reporter.WriteCSVReport(payouts)
+			reporting.PrintPreparedSummary(log.Writer(), prepared)
+
+			if err = prepared.Write(args[0]); err != nil {
+				reporter.Log(err)
+				os.Exit(1)
+			}
+			reporter.Log(fmt.Sprintf("wrote unsigned payout to %s, sign it with payman sign %s and broadcast it with payman payout broadcast %s", args[0], args[0], args[0]))
+		},
+	}
+
+	prepare.Flags().StringVar(&source, "source", "", "address of the wallet paying, if it is not the address of --keystore or --signer (e.g. --source=<pkh>)")
+	prepare.Flags().StringVar(&publicKey, "public-key", "", "public key of the wallet paying, to reveal it with the payout if it was never revealed and is not behind --signer (e.g. --public-key=<edpk>)")
+	return prepare
+}
+
+func newBroadcastCommand(conf *options.Options) *cobra.Command {
+	var broadcast = &cobra.Command{
+		Use:   "broadcast <file>",
+		Short: "broadcast preapplies and injects a payout prepared with payman payout prepare and signed with payman sign",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+
+			loadOptions(cmd, conf)
+			if conf.Confirmations < 0 {
+				printPreflight([]string{"[payout][preflight] error: confirmations cannot be negative (e.g. --confirmations=2)"}, nil)
+			}
+
+			f, err := os.Create(conf.File)
+			if err != nil {
+				fmt.Printf("could not open logging file: %v\n", err)
+			}
+			defer f.Close()
+
+			log := log.New(f, "", log.Ldate|log.Ltime|log.Lshortfile)
+
+			prepared, err := pay.ReadPrepared(args[0])
+			if err != nil {
+				log.Println(err)
+				os.Exit(1)
+			}
+			reporting.PrintPreparedSummary(log.Writer(), prepared)
+
+			gt, err := goTezos.NewGoTezos(conf.URL)
+			if err != nil {
+				log.Println(fmt.Sprintf("could not connect to network: %v\n", err))
+			}
+
+			book, err := ledger.Open(conf.Ledger)
+			if err != nil {
+				log.Println(fmt.Sprintf("could not open ledger: %v", err))
+				os.Exit(1)
+			}
+
+			payer := pay.NewPayer(gt, nil, book, conf)
+			ops, err := payer.Broadcast(prepared)
+			for _, op := range ops {
+				log.Println("Successful operation: " + string(op))
+			}
+			if err != nil {
+				log.Println(fmt.Sprintf("could not broadcast payout for %s: %v", prepared.Entry.Delegate, err))
+				f.Close()
+				os.Exit(1)
+			}
+		},
+	}
+
+	return broadcast
+}
+
+func newSignCommand() *cobra.Command {
+	var conf options.Options
+	var yes bool
+
+	var sign = &cobra.Command{
+		Use:   "sign <file>",
+		Short: "sign signs a payout prepared with payman payout prepare, and needs no network access",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+
+			confs := loadOptions(cmd, &conf)
+			printPreflight(walletPreflight(confs[0]), nil)
+
+			prepared, err := pay.ReadPrepared(args[0])
+			if err != nil {
+				exit(err)
+			}
+			reporting.PrintPreparedSummary(os.Stdout, prepared)
+
+			if !yes && !canPrompt() {
+				exit(fmt.Errorf("no terminal to confirm signing on, review the summary above and pass --yes to sign"))
+			}
+			if !yes && !confirm(fmt.Sprintf("sign the %d operations above from %s? [y/N] ", len(prepared.Operations), prepared.Source)) {
+				exit(fmt.Errorf("payout not signed"))
+			}
+
+			s, err := newSigner(&confs[0])
+			if err != nil {
+				exit(err)
+			}
+			if err = prepared.Sign(s); err != nil {
+				exit(err)
+			}
+			if err = prepared.Write(args[0]); err != nil {
+				exit(err)
+			}
+
+			fmt.Printf("signed %d operations in %s, broadcast them with payman payout broadcast %s\n", len(prepared.Operations), args[0], args[0])
+		},
+	}
+
+	flags := sign.Flags()
+	flags.StringVarP(&conf.Secret, "secret", "s", "", "encrypted secret key of the wallet paying (e.g. --secret=<sk>)")
+	flags.StringVar(&conf.SecretFile, "secret-file", "", "read the encrypted secret key of the wallet paying from <file>, which only its owner may access (e.g. path/to/my/file/secret)")
+	flags.StringVar(&conf.Keystore, "keystore", "", "read the encrypted secret key of the wallet paying from a keystore made with payman wallet import (e.g. path/to/my/file/keystore.json)")
+	flags.StringVarP(&conf.Password, "password", "k", "", "password to the secret key of the wallet paying, prompted for if not passed (e.g. --password=<passwd>)")
+	flags.StringVar(&conf.PasswordFile, "password-file", "", "read the password to the secret key of the wallet paying from <file>, which only its owner may access (e.g. path/to/my/file/password)")
+	flags.StringVar(&conf.Signer, "signer", "", "sign with a key held by a remote signer instead of a secret key (e.g. http://localhost:6732/<pkh>)")
+	flags.BoolVarP(&yes, "yes", "y", false, "sign without asking for confirmation (default false)(e.g. --yes)")
+	return sign
+}
+
+// preparePreflight checks the options of a payout to prepare, and returns the errors that stop it
+func preparePreflight(confs []options.Options, source string) []string {
+	errors := []string{}
+
+	conf := confs[0]
+	if len(confs) > 1 {
+		errors = append(errors, "[payout][preflight] error: prepare one delegate in the delegates file at a time (e.g. --delegate=<pkh>)")
+	}
+	if conf.Resume {
+		errors = append(errors, "[payout][preflight] error: cannot resume a prepared payout, broadcast it again instead (e.g. payman payout broadcast <file>)")
+	}
+	if conf.Service {
+		errors = append(errors, "[payout][preflight] error: cannot prepare payouts while running as a service")
+	}
+	if (conf.CycleFrom != 0 || conf.Backfill) && !conf.Merge {
+		errors = append(errors, "[payout][preflight] error: prepare one payout at a time, merge a range of cycles into one (e.g. --merge)")
+	}
+	if source == "" && conf.Keystore == "" && conf.Signer == "" {
+		errors = append(errors, "[payout][preflight] error: no address passed for the wallet paying (e.g. --source=<pkh>)")
+	}
+	if err := address.Check(source); source != "" && err != nil {
+		errors = append(errors, fmt.Sprintf("[payout][preflight] error: source %v (e.g. --source=<pkh>)", err))
+	}
+	return errors
+}
+
+// sourceKey returns the address and public key of the wallet paying: the address passed, or the address
+// of the keystore or remote signer passed, none of which need the wallet's password, and the public key
+// passed or held by the remote signer. The public key is empty if neither knows it.
+func sourceKey(conf *options.Options, source, publicKey string) (string, string, error) {
+	switch {
+	case source != "":
+		return source, publicKey, nil
+	case conf.Signer != "":
+		remote, err := signer.NewRemote(conf.Signer)
+		if err != nil {
+			return "", "", err
+		}
+		if publicKey == "" {
+			if publicKey, err = remote.PublicKey(); err != nil {
+				return "", "", fmt.Errorf("could not reach remote signer for %s: %v", conf.Delegate, err)
+			}
+		}
+		return remote.Address(), publicKey, nil
+	case conf.Keystore != "":
+		k, err := keystore.Read(conf.Keystore)
+		if err != nil {
+			return "", "", err
+		}
+		return k.Address, publicKey, nil
+	}
+	return "", "", fmt.Errorf("no address passed for the wallet paying")
+}
+
+// confirm asks a yes or no question on the terminal, and returns true if the answer is yes
+func confirm(msg string) bool {
+	fmt.Fprint(os.Stderr, msg)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
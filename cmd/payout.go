@@ -1,12 +1,12 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 
+	"github.com/DefinitelyNotAGoat/payman/address"
+	"github.com/DefinitelyNotAGoat/payman/ledger"
 	"github.com/DefinitelyNotAGoat/payman/reddit"
 	"github.com/DefinitelyNotAGoat/payman/twitter"
 
@@ -14,62 +14,25 @@ import (
 	pay "github.com/DefinitelyNotAGoat/payman/payer"
 	"github.com/DefinitelyNotAGoat/payman/reporting"
 	"github.com/DefinitelyNotAGoat/payman/server"
+	"github.com/DefinitelyNotAGoat/payman/signer"
 
 	goTezos "github.com/DefinitelyNotAGoat/go-tezos"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 func newPayoutCommand() *cobra.Command {
 	var conf options.Options
-	var blacklistFile string
-
-	preflight := func(conf options.Options) {
-		errors := []string{}
-		warnings := []string{}
-
-		if conf.Secret == "" {
-			errors = append(errors, "[payout][preflight] error: no secret key passed for payout wallet (e.g. --secret=<sk>)")
-		}
-		if conf.Password == "" {
-			errors = append(errors, "[payout][preflight] error: no password passed for payout wallet (e.g. --password=<passwd>)")
-		}
-
-		if conf.PaymentsOverride.File == "" {
-			if conf.Cycle == 0 && !conf.Service {
-				errors = append(errors, "[payout][preflight] error: no cycle passed to payout for (e.g. --cycle=95)")
-			}
-			if conf.Fee == -1 {
-				errors = append(errors, "[payout][preflight] error: no delegation fee passed for payout (e.g. --fee=0.05)")
-			}
-			if conf.Delegate == "" {
-				errors = append(errors, "[payout][preflight] error: no delegate passed for payout (e.g. --delegate=<pkh>)")
-			}
-		}
-
-		if conf.NetworkFee == 1270 {
-			warnings = append(warnings, "[payout][preflight] warning: no network fee passed for payout, using default 1270 mutez")
-		}
-		if conf.NetworkFee == 1270 {
-			warnings = append(warnings, "[payout][preflight] warning: no gas limit passed for payout, using default 10200 mutez")
-		}
-
-		for _, err := range errors {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-
-		for _, warning := range warnings {
-			fmt.Println(warning)
-		}
-	}
+	var yes bool
 
 	var payout = &cobra.Command{
 		Use:   "payout",
 		Short: "Payout pays out rewards to delegations.",
-		Long:  "Payout pays out rewards to delegations for the delegate passed.",
+		Long:  "Payout pays out rewards to delegations for the delegate passed, or for every delegate in the delegates file passed.",
 		Run: func(cmd *cobra.Command, args []string) {
 
-			preflight(conf)
+			confs := loadOptions(cmd, &conf)
+			printPreflight(payoutPreflight(confs))
 
 			f, err := os.Create(conf.File)
 			if err != nil {
@@ -88,41 +51,56 @@ func newPayoutCommand() *cobra.Command {
 				reporter.Log(fmt.Sprintf("could not connect to network: %v\n", err))
 			}
 
-			if conf.PaymentsOverride.File != "" {
-				conf.PaymentsOverride.Payments, err = conf.PaymentsOverride.ReadPaymentsOverride()
-				if err != nil {
-					reporter.Log(fmt.Sprintf("could not parse payments override into payments: %v", err))
-					os.Exit(1)
-				}
-			}
-
-			wallet, err := gt.Account.ImportEncryptedWallet(conf.Password, conf.Secret)
+			book, err := ledger.Open(conf.Ledger)
 			if err != nil {
-				reporter.Log(fmt.Sprintf("could not import wallet: %v", err))
+				reporter.Log(fmt.Sprintf("could not open ledger: %v", err))
 				os.Exit(1)
 			}
 
-			if blacklistFile != "" {
-				jsonFile, err := os.Open(blacklistFile)
-				if err != nil {
-					reporter.Log(fmt.Sprintf("could not read in blacklist: %s", blacklistFile))
-					os.Exit(1)
+			delegates := []server.Delegate{}
+			cycles := [][]int{}
+			for i := range confs {
+				c := &confs[i]
+				if c.PaymentsOverride.File != "" && !c.Resume {
+					c.PaymentsOverride.Payments, err = c.PaymentsOverride.ReadPaymentsOverride()
+					if err != nil {
+						reporter.Log(fmt.Sprintf("could not parse payments override into payments: %v", err))
+						os.Exit(1)
+					}
+
+					reporting.PrintOverrideSummary(os.Stdout, c.PaymentsOverride)
+					if !yes && !canPrompt() {
+						exit(fmt.Errorf("no terminal to confirm the payments override on, review the summary above and pass --yes to pay it"))
+					}
+					if !yes && !confirm(fmt.Sprintf("pay the %d payments above? [y/N] ", len(c.PaymentsOverride.Payments))) {
+						exit(fmt.Errorf("payments override not paid"))
+					}
 				}
-				defer jsonFile.Close()
 
-				byteValue, err := ioutil.ReadAll(jsonFile)
+				paySigner, err := newSigner(c)
 				if err != nil {
-					reporter.Log(fmt.Sprintf("could not read in blacklist: %s", blacklistFile))
+					reporter.Log(err)
 					os.Exit(1)
 				}
 
-				var blacklist []string
-				err = json.Unmarshal(byteValue, &blacklist)
-				if err != nil {
-					reporter.Log("could not unmarshal blacklist from json string array")
+				if err = c.ReadFiles(); err != nil {
+					reporter.Log(err)
 					os.Exit(1)
 				}
-				conf.Blacklist = blacklist
+
+				delegates = append(delegates, server.Delegate{Signer: paySigner, Conf: c})
+
+				if !c.Service {
+					delegateCycles, err := payoutCycles(gt, book, c)
+					if err != nil {
+						reporter.Log(err)
+						os.Exit(1)
+					}
+					if len(delegateCycles) == 0 {
+						reporter.Log(fmt.Sprintf("no unpaid cycles to backfill for %s", c.Delegate))
+					}
+					cycles = append(cycles, delegateCycles)
+				}
 			}
 
 			var redditBot *reddit.Bot
@@ -149,35 +127,55 @@ func newPayoutCommand() *cobra.Command {
 
 			if conf.Service {
 
-				serv := server.NewPayoutServer(gt, wallet, reporter, redditBot, twitterBot, &conf)
+				serv := server.NewPayoutServer(gt, book, reporter, redditBot, twitterBot, delegates)
 				serv.Serve()
 
 			} else {
-				payer := pay.NewPayer(gt, wallet, &conf)
-				payouts, ops, err := payer.Payout()
-				if err != nil {
-					log.Fatal(err)
-				}
-
-				for _, op := range ops {
-					reporter.Log("Successful operation: " + string(op))
-					if conf.RedditAgent != "" && redditBotStatus {
-						err := redditBot.Post(string(op), conf.Cycle)
+				reports := []pay.Report{}
+				failed := false
+				for i, d := range delegates {
+					payer := pay.NewPayer(gt, d.Signer, book, d.Conf)
+					for _, group := range groupCycles(cycles[i], conf.Merge) {
+						payouts, ops, err := payer.PayoutCycles(group)
 						if err != nil {
-							reporter.Log(fmt.Sprintf("could not post to reddit: %v", err))
+							reporter.Log(fmt.Sprintf("could not pay out %s at cycles %v: %v", d.Conf.Delegate, group, err))
+							failed = true
+							break
 						}
-					}
 
-					if conf.Twitter && twitterBotStatus {
-						err := twitterBot.Post(string(op), conf.Cycle)
-						if err != nil {
-							reporter.Log(fmt.Sprintf("could not post to twitter: %v", err))
+						for _, op := range ops {
+							reporter.Log("Successful operation: " + string(op))
+							if conf.RedditAgent != "" && redditBotStatus {
+								err := redditBot.Post(string(op), reporting.FormatCycles(payouts))
+								if err != nil {
+									reporter.Log(fmt.Sprintf("could not post to reddit: %v", err))
+								}
+							}
+
+							if conf.Twitter && twitterBotStatus {
+								err := twitterBot.Post(string(op), reporting.FormatCycles(payouts))
+								if err != nil {
+									reporter.Log(fmt.Sprintf("could not post to twitter: %v", err))
+								}
+							}
+						}
+						if !conf.Resume {
+							if len(delegates) > 1 || len(cycles[i]) > 1 {
+								reporter.Log(fmt.Sprintf("payout for %s at cycles %v", d.Conf.Delegate, group))
+							}
+							reporter.PrintPaymentsTable(payouts)
+							reporter.WriteCSVReport(payouts)
+							reports = append(reports, payouts)
 						}
 					}
 				}
-				if len(conf.PaymentsOverride.Payments) == 0 {
-					reporter.PrintPaymentsTable(payouts)
-					reporter.WriteCSVReport(payouts)
+
+				if len(reports) > 1 {
+					reporter.PrintDelegatesSummaryTable(reports)
+				}
+				if failed {
+					f.Close()
+					os.Exit(1)
 				}
 			}
 
@@ -185,23 +183,236 @@ func newPayoutCommand() *cobra.Command {
 		},
 	}
 
-	payout.PersistentFlags().StringVarP(&conf.Delegate, "delegate", "d", "", "public key hash of the delegate that's paying out (e.g. --delegate=<phk>)")
-	payout.PersistentFlags().StringVarP(&conf.Secret, "secret", "s", "", "encrypted secret key of the wallet paying (e.g. --secret=<sk>)")
-	payout.PersistentFlags().StringVarP(&conf.Password, "password", "k", "", "password to the secret key of the wallet paying (e.g. --password=<passwd>)")
-	payout.PersistentFlags().BoolVar(&conf.Service, "serve", false, "run service to payout for all new cycles going foward (default false)(e.g. --serve)")
-	payout.PersistentFlags().IntVarP(&conf.Cycle, "cycle", "c", 0, "cycle to payout for (e.g. 95)")
-	payout.PersistentFlags().StringVarP(&conf.URL, "node", "u", "http://127.0.0.1:8732", "address to the node to query (default http://127.0.0.1:8732)(e.g. https://mainnet-node.tzscan.io:443)")
-	payout.PersistentFlags().Float32VarP(&conf.Fee, "fee", "f", -1, "fee for the delegate (e.g. 0.05 = 5%)")
-	payout.PersistentFlags().IntVar(&conf.NetworkFee, "network-fee", 1270, "network fee for each transaction in mutez (default 1270)(e.g. 2000)")
-	payout.PersistentFlags().IntVar(&conf.NetworkGasLimit, "gas-limit", 10200, "network gas limit for each transaction in mutez (default 10200)(e.g. 10300)")
-	payout.PersistentFlags().StringVarP(&conf.File, "log-file", "l", "/dev/stdout", "file to log to (default stdout)(e.g. ./payman.log)")
-	payout.PersistentFlags().StringVarP(&conf.RedditAgent, "reddit", "r", "", "path to reddit agent file (initiates reddit bot)(e.g. https://turnage.gitbooks.io/graw/content/chapter1.html)")
-	payout.PersistentFlags().StringVar(&conf.RedditTitle, "reddit-title", "", "pre title for the reddit bot to post (e.g. DefinitelyNotABot: -- will read DefinitelyNotABot: Payout for Cycle <cycle>)")
-	payout.PersistentFlags().StringVar(&conf.TwitterPath, "twitter-path", "", "path to twitter.yml file containing API keys if not in current dir (e.g. path/to/my/file/)")
-	payout.PersistentFlags().StringVar(&conf.TwitterTitle, "twitter-title", "", "pre title for the twitter bot to post (e.g. DefinitelyNotABot: -- will read DefinitelyNotABot: Payout for Cycle <cycle>)")
-	payout.PersistentFlags().BoolVarP(&conf.Twitter, "twitter", "t", false, "turn on twitter bot, will look for api keys in twitter.yml in current dir or --twitter-path (e.g. --twitter)")
-	payout.PersistentFlags().IntVar(&conf.PaymentMinimum, "payout-min", 0, "will only payout to addresses that meet the payout minimum (e.g. --payout-min=<mutez>)")
-	payout.PersistentFlags().StringVar(&conf.PaymentsOverride.File, "payments-override", "", "overrides the rewards calculation and allows you to pass in your own payments in a json file (e.g. path/to/my/file/payments.json)")
-	payout.PersistentFlags().StringVar(&blacklistFile, "blacklist", "", "will not pay out to addresses in json <file> (string array)")
+	payoutFlags(payout.PersistentFlags(), &conf)
+	payout.Flags().BoolVarP(&yes, "yes", "y", false, "pay a payments override without asking for confirmation (default false)(e.g. --yes)")
+	payout.AddCommand(newPrepareCommand(&conf), newBroadcastCommand(&conf))
 	return payout
 }
+
+// payoutFlags registers the flags of the payout command on flags, bound to conf
+func payoutFlags(flags *pflag.FlagSet, conf *options.Options) {
+	flags.StringVarP(&conf.Delegate, "delegate", "d", "", "public key hash of the delegate that's paying out, or with --delegates the only delegate in the file to pay out for (e.g. --delegate=<phk>)")
+	flags.StringVar(&conf.Delegates.File, "delegates", "", "pays out every delegate in json <file>, each with its own wallet, fee, fee schedule, blacklist, redirects and payout minimum (e.g. path/to/my/file/delegates.json)")
+	flags.StringVarP(&conf.Secret, "secret", "s", "", "encrypted secret key of the wallet paying (e.g. --secret=<sk>)")
+	flags.StringVar(&conf.SecretFile, "secret-file", "", "read the encrypted secret key of the wallet paying from <file>, which only its owner may access (e.g. path/to/my/file/secret)")
+	flags.StringVar(&conf.Keystore, "keystore", "", "read the encrypted secret key of the wallet paying from a keystore made with payman wallet import (e.g. path/to/my/file/keystore.json)")
+	flags.StringVarP(&conf.Password, "password", "k", "", "password to the secret key of the wallet paying, prompted for if not passed (e.g. --password=<passwd>)")
+	flags.StringVar(&conf.PasswordFile, "password-file", "", "read the password to the secret key of the wallet paying from <file>, which only its owner may access (e.g. path/to/my/file/password)")
+	flags.StringVar(&conf.Signer, "signer", "", "sign payouts with a key held by a remote signer instead of a secret key, no secret or password needed (e.g. http://localhost:6732/<pkh>)")
+	flags.BoolVar(&conf.Service, "serve", false, "run service to payout for all new cycles going foward (default false)(e.g. --serve)")
+	flags.IntVarP(&conf.Cycle, "cycle", "c", 0, "cycle to payout for, or with --serve the first cycle to payout for if the ledger is empty (e.g. 95)")
+	flags.IntVar(&conf.CycleFrom, "cycle-from", 0, "first cycle of a range of cycles to payout for in order, or with --backfill the first cycle to look for unpaid cycles from (e.g. 95)")
+	flags.IntVar(&conf.CycleTo, "cycle-to", 0, "last cycle of a range of cycles to payout for, or with --backfill the last cycle to look for unpaid cycles up to (default latest payable cycle)(e.g. 98)")
+	flags.BoolVar(&conf.Backfill, "backfill", false, "payout for every cycle the ledger does not show as paid, from the first cycle paid in the ledger up to the latest payable cycle (default false)(e.g. --backfill)")
+	flags.BoolVar(&conf.Merge, "merge", false, "merge the payments of every cycle paid out into one payout, paying each address once to save network fees (default false)(e.g. --merge)")
+	flags.IntVar(&conf.CycleOffset, "cycle-offset", 0, "with --serve or --backfill, pay out this many cycles before rewards are unfrozen, up to preserved_cycles (default 0)(e.g. 5)")
+	flags.StringVarP(&conf.URL, "node", "u", "http://127.0.0.1:8732", "address to the node to query (default http://127.0.0.1:8732)(e.g. https://mainnet-node.tzscan.io:443)")
+	flags.StringVarP(&conf.Fee, "fee", "f", "", "fee for the delegate as an exact decimal or fraction (e.g. 0.05 = 5%)")
+	flags.StringVar(&conf.FeeSchedule.File, "fee-schedule", "", "charges the fee rates in json <file> to the addresses listed, and its default rate to everyone else (e.g. path/to/my/file/fees.json)")
+	flags.StringVar(&conf.Remainder, "remainder", options.RemainderBaker, "who gets the mutez left over from rounding every share down, baker or delegators (default baker)(e.g. --remainder=delegators)")
+	flags.IntVar(&conf.NetworkFee, "network-fee", 1270, "network fee for each transaction in mutez, when simulation is off (default 1270)(e.g. 2000)")
+	flags.IntVar(&conf.NetworkGasLimit, "gas-limit", 10200, "network gas limit for each transaction, when simulation is off (default 10200)(e.g. 10300)")
+	flags.BoolVar(&conf.Simulate, "simulate", true, "simulate each batch on the node to set the gas and storage limits and minimal fee of each transaction (default true)(e.g. --simulate=false)")
+	flags.IntVar(&conf.BatchSize, "batch-size", 100, "most transactions in each operation, batches are also kept under the network's gas and size limits per operation (default 100)(e.g. 50)")
+	flags.Float64Var(&conf.SimulationMargin, "simulation-margin", 0.1, "fraction added to the simulated gas and storage of each transaction as a safety margin (default 0.1)(e.g. 0.2 = 20%)")
+	flags.StringVarP(&conf.File, "log-file", "l", "/dev/stdout", "file to log to (default stdout)(e.g. ./payman.log)")
+	flags.StringVarP(&conf.RedditAgent, "reddit", "r", "", "path to reddit agent file (initiates reddit bot)(e.g. https://turnage.gitbooks.io/graw/content/chapter1.html)")
+	flags.StringVar(&conf.RedditTitle, "reddit-title", "", "pre title for the reddit bot to post (e.g. DefinitelyNotABot: -- will read DefinitelyNotABot: Payout for Cycle <cycle>)")
+	flags.StringVar(&conf.TwitterPath, "twitter-path", "", "path to twitter.yml file containing API keys if not in current dir (e.g. path/to/my/file/)")
+	flags.StringVar(&conf.TwitterTitle, "twitter-title", "", "pre title for the twitter bot to post (e.g. DefinitelyNotABot: -- will read DefinitelyNotABot: Payout for Cycle <cycle>)")
+	flags.BoolVarP(&conf.Twitter, "twitter", "t", false, "turn on twitter bot, will look for api keys in twitter.yml in current dir or --twitter-path (e.g. --twitter)")
+	flags.IntVar(&conf.PaymentMinimum, "payout-min", 0, "will only payout to addresses that meet the payout minimum (e.g. --payout-min=<mutez>)")
+	flags.Int64Var(&conf.MaxTotal, "max-total", 0, "refuse to pay out more than this many mutez in total in one payout, 0 for no limit (default 0)(e.g. --max-total=<mutez>)")
+	flags.Int64Var(&conf.MaxPayment, "max-payment", 0, "refuse to pay out more than this many mutez to a single address in one payout, 0 for no limit (default 0)(e.g. --max-payment=<mutez>)")
+	flags.Float64Var(&conf.MaxDeviation, "max-deviation", 0, "refuse to pay out a total per cycle further than this fraction from the previous payout in the ledger, 0 for no limit (default 0)(e.g. 0.5 = 50%)")
+	flags.BoolVar(&conf.OverrideLimits, "override-limits", false, "pay out even if the payout breaks --max-total, --max-payment, --max-deviation or pays more than the cycle rewards (default false)(e.g. --override-limits)")
+	flags.StringVar(&conf.Underfunded, "underfunded", options.UnderfundedRefuse, "what to do when the wallet paying cannot afford the payout: refuse to start, or partial to pay the smallest payouts it can afford and carry the rest over (default refuse)(e.g. --underfunded=partial --carry-over)")
+	flags.StringVar(&conf.PaymentsOverride.File, "payments-override", "", "overrides the rewards calculation and allows you to pass in your own payments in a csv or json file, detected by its extension (e.g. path/to/my/file/payments.csv)")
+	flags.StringVar(&conf.BlacklistFile, "blacklist", "", "will not pay out to addresses in json <file> (string array)")
+	flags.StringVar(&conf.Redirects.File, "redirects", "", "pays the rewards of delegations to the addresses they map to in json <file> (e.g. path/to/my/file/redirects.json)")
+	flags.BoolVar(&conf.CarryOver, "carry-over", false, "carry rewards under the payout minimum over in the ledger, and pay them once they add up to the minimum (default false)(e.g. --carry-over)")
+	flags.StringVar(&conf.Ledger, "ledger", "payman.ledger.json", "file recording every payout made, used to refuse paying a cycle twice (default payman.ledger.json)(e.g. path/to/my/file/ledger.json)")
+	flags.BoolVar(&conf.Resume, "resume", false, "finish a failed payout recorded in the ledger, re-forging only the batches that never reached the network (default false)(e.g. --resume --cycle=95)")
+	flags.IntVar(&conf.Confirmations, "confirmations", 2, "number of blocks to wait for on top of each payout operation before it is considered paid, 0 to not wait (default 2)(e.g. 5)")
+	flags.BoolVar(&conf.Force, "force", false, "pay out even if the ledger shows the cycle as already paid (default false)(e.g. --force)")
+}
+
+// payoutPreflight checks the options of every delegate to pay out, and returns the errors that
+// stop the payout and the warnings that do not
+func payoutPreflight(confs []options.Options) ([]string, []string) {
+	errors := []string{}
+	for _, conf := range confs {
+		errors = append(errors, walletPreflight(conf)...)
+	}
+
+	paymentErrors, warnings := paymentsPreflight(confs)
+	return append(errors, paymentErrors...), warnings
+}
+
+// walletPreflight checks a secret key and password, or a remote signer, were passed for the payout wallet
+func walletPreflight(conf options.Options) []string {
+	errors := []string{}
+	if conf.Signer != "" {
+		if _, err := signer.NewRemote(conf.Signer); err != nil {
+			errors = append(errors, fmt.Sprintf("[payout][preflight] error: %v", err))
+		}
+		return errors
+	}
+
+	if conf.Secret == "" && conf.SecretFile == "" && conf.Keystore == "" {
+		errors = append(errors, "[payout][preflight] error: no secret key or remote signer passed for payout wallet (e.g. --keystore=<file>)")
+	}
+	if conf.Password == "" && conf.PasswordFile == "" && !canPrompt() {
+		errors = append(errors, "[payout][preflight] error: no password passed for payout wallet and no terminal to prompt for it (e.g. --password-file=<file>)")
+	}
+	return errors
+}
+
+// addressesPreflight checks the address of every delegate and every address in the files passed, so
+// a typo stops the payout before anything is read from the node
+func addressesPreflight(confs []options.Options) []string {
+	errors := []string{}
+	for _, conf := range confs {
+		if conf.Delegate != "" {
+			if err := address.Check(conf.Delegate); err != nil {
+				errors = append(errors, fmt.Sprintf("[payout][preflight] error: delegate %v (e.g. --delegate=<pkh>)", err))
+			}
+		}
+		if err := conf.ReadFiles(); err != nil {
+			errors = append(errors, fmt.Sprintf("[payout][preflight] error: %v", err))
+		}
+		if conf.PaymentsOverride.File != "" && !conf.Resume {
+			if _, err := conf.PaymentsOverride.ReadPaymentsOverride(); err != nil {
+				errors = append(errors, fmt.Sprintf("[payout][preflight] error: could not read in payments override %s: %v", conf.PaymentsOverride.File, err))
+			}
+		}
+	}
+	return errors
+}
+
+// paymentsPreflight checks the options that decide what is paid out for every delegate, and returns
+// the errors that stop the payout and the warnings that do not
+func paymentsPreflight(confs []options.Options) ([]string, []string) {
+	errors := []string{}
+	warnings := []string{}
+
+	for _, conf := range confs {
+		if !conf.Resume && conf.PaymentsOverride.File == "" {
+			if conf.Fee == "" && conf.FeeSchedule.File == "" {
+				errors = append(errors, "[payout][preflight] error: no delegation fee passed for payout (e.g. --fee=0.05)")
+			}
+			if _, err := options.ParseRate(conf.Fee); conf.Fee != "" && err != nil {
+				errors = append(errors, fmt.Sprintf("[payout][preflight] error: %v (e.g. --fee=0.05)", err))
+			}
+			if conf.Delegate == "" {
+				errors = append(errors, "[payout][preflight] error: no delegate passed for payout (e.g. --delegate=<pkh>)")
+			}
+		}
+	}
+
+	conf := confs[0]
+	if conf.Resume {
+		if conf.Cycle == 0 {
+			errors = append(errors, "[payout][preflight] error: no cycle passed to resume payout for (e.g. --cycle=95)")
+		}
+		if conf.Service {
+			errors = append(errors, "[payout][preflight] error: cannot resume a payout while running as a service")
+		}
+		if len(confs) > 1 {
+			errors = append(errors, "[payout][preflight] error: resume one delegate in the delegates file at a time (e.g. --delegate=<pkh>)")
+		}
+	} else if conf.PaymentsOverride.File == "" {
+		if conf.Cycle == 0 && conf.CycleFrom == 0 && !conf.Backfill && !conf.Service {
+			errors = append(errors, "[payout][preflight] error: no cycle passed to payout for (e.g. --cycle=95)")
+		}
+		if conf.Remainder != options.RemainderBaker && conf.Remainder != options.RemainderDelegators {
+			errors = append(errors, "[payout][preflight] error: remainder must be baker or delegators (e.g. --remainder=baker)")
+		}
+	} else {
+		if conf.Cycle == 0 && conf.CycleFrom == 0 && !conf.Backfill {
+			errors = append(errors, "[payout][preflight] error: no cycle passed to record the payments override under in the ledger (e.g. --cycle=95)")
+		}
+		if len(confs) > 1 {
+			errors = append(errors, "[payout][preflight] error: cannot override payments for more than one delegate (e.g. --delegate=<pkh>)")
+		}
+	}
+
+	errors = append(errors, cyclesPreflight(conf)...)
+	errors = append(errors, addressesPreflight(confs)...)
+
+	if conf.CycleOffset < 0 {
+		errors = append(errors, "[payout][preflight] error: cycle offset cannot be negative (e.g. --cycle-offset=1)")
+	}
+
+	if conf.Confirmations < 0 {
+		errors = append(errors, "[payout][preflight] error: confirmations cannot be negative (e.g. --confirmations=2)")
+	}
+
+	for _, c := range confs {
+		if c.MaxTotal < 0 || c.MaxPayment < 0 {
+			errors = append(errors, fmt.Sprintf("[payout][preflight] error: spending limits for %s cannot be negative (e.g. --max-total=<mutez>)", c.Delegate))
+		}
+	}
+	if conf.Underfunded != options.UnderfundedRefuse && conf.Underfunded != options.UnderfundedPartial {
+		errors = append(errors, "[payout][preflight] error: underfunded must be refuse or partial (e.g. --underfunded=refuse)")
+	}
+	if conf.Underfunded == options.UnderfundedPartial && !conf.CarryOver {
+		errors = append(errors, "[payout][preflight] error: partial payouts carry the payouts they cannot afford over, and need carry over (e.g. --underfunded=partial --carry-over)")
+	}
+	if conf.MaxDeviation < 0 {
+		errors = append(errors, "[payout][preflight] error: max deviation cannot be negative (e.g. --max-deviation=0.5)")
+	}
+	if conf.OverrideLimits {
+		warnings = append(warnings, "[payout][preflight] warning: spending limits are overridden, payouts are not checked against them")
+	}
+
+	if conf.BatchSize < 1 {
+		errors = append(errors, "[payout][preflight] error: batch size must be at least 1 (e.g. --batch-size=50)")
+	}
+	if conf.SimulationMargin < 0 {
+		errors = append(errors, "[payout][preflight] error: simulation margin cannot be negative (e.g. --simulation-margin=0.1)")
+	}
+	if !conf.Simulate && conf.NetworkFee == 1270 {
+		warnings = append(warnings, "[payout][preflight] warning: no network fee passed for payout, using default 1270 mutez")
+	}
+	if !conf.Simulate && conf.NetworkGasLimit == 10200 {
+		warnings = append(warnings, "[payout][preflight] warning: no gas limit passed for payout, using default 10200")
+	}
+
+	return errors, warnings
+}
+
+// newSigner returns the signer of the wallet paying out for conf: a remote signer if one was passed,
+// otherwise the wallet of the secret key read in and decrypted with its password, prompted for if needed
+func newSigner(conf *options.Options) (signer.Signer, error) {
+	if conf.Signer != "" {
+		remote, err := signer.NewRemote(conf.Signer)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = remote.PublicKey(); err != nil {
+			return nil, fmt.Errorf("could not reach remote signer for %s: %v", conf.Delegate, err)
+		}
+		return remote, nil
+	}
+
+	if err := conf.ReadSecrets(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if conf.Password == "" {
+		conf.Password, err = prompt(fmt.Sprintf("password for the wallet paying out for %s: ", conf.Delegate))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// importing a wallet only decrypts its secret key, it does not need a node
+	account := goTezos.AccountService{}
+	wallet, err := account.ImportEncryptedWallet(conf.Password, conf.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("could not import wallet for %s: %v", conf.Delegate, err)
+	}
+	return signer.NewWallet(wallet), nil
+}
@@ -6,39 +6,24 @@ import (
 	"os"
 
 	goTezos "github.com/DefinitelyNotAGoat/go-tezos"
+	"github.com/DefinitelyNotAGoat/payman/ledger"
 	"github.com/DefinitelyNotAGoat/payman/options"
 	pay "github.com/DefinitelyNotAGoat/payman/payer"
 	"github.com/DefinitelyNotAGoat/payman/reporting"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 func newReportCommand() *cobra.Command {
 	var conf options.Options
 
-	preflight := func(conf options.Options) {
-		errors := []string{}
-		if conf.Delegate == "" {
-			errors = append(errors, "[payout][preflight] error: no delegate passed for payout (e.g. --delegate=<pkh>)")
-		}
-		if conf.Cycle == 0 {
-			errors = append(errors, "[payout][preflight] error: no cycle passed to payout for (e.g. --cycle=95)")
-		}
-		if conf.Fee == -1 {
-			errors = append(errors, "[payout][preflight] error: no delegation fee passed for payout (e.g. --fee=0.05)")
-		}
-
-		for _, err := range errors {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-	}
-
 	var report = &cobra.Command{
 		Use:   "report",
 		Short: "report simulates a payout and generates a table and csv report",
 		Run: func(cmd *cobra.Command, args []string) {
 
-			preflight(conf)
+			confs := loadOptions(cmd, &conf)
+			printPreflight(reportPreflight(confs), nil)
 
 			f, err := os.Create(conf.File)
 			if err != nil {
@@ -56,28 +41,108 @@ func newReportCommand() *cobra.Command {
 			if err != nil {
 				reporter.Log(fmt.Sprintf("could not connect to network: %v\n", err))
 			}
-			conf.Dry = true
 
-			wallet := goTezos.Wallet{}
-			payer := pay.NewPayer(gt, wallet, &conf)
-			payouts, _, err := payer.Payout()
-			if err != nil {
-				log.Fatal(err)
+			var book *ledger.Ledger
+			if conf.CarryOver || conf.Backfill {
+				book, err = ledger.Open(conf.Ledger)
+				if err != nil {
+					reporter.Log(fmt.Sprintf("could not open ledger: %v", err))
+					os.Exit(1)
+				}
 			}
 
-			reporter.PrintPaymentsTable(payouts)
-			reporter.WriteCSVReport(payouts)
+			reports := []pay.Report{}
+			for i := range confs {
+				c := &confs[i]
+				c.Dry = true
+				if err = c.ReadFiles(); err != nil {
+					reporter.Log(err)
+					os.Exit(1)
+				}
+
+				cycles, err := payoutCycles(gt, book, c)
+				if err != nil {
+					reporter.Log(err)
+					os.Exit(1)
+				}
+				if len(cycles) == 0 {
+					reporter.Log(fmt.Sprintf("no unpaid cycles to backfill for %s", c.Delegate))
+				}
+
+				payer := pay.NewPayer(gt, nil, book, c)
+				for _, group := range groupCycles(cycles, c.Merge) {
+					payouts, _, err := payer.PayoutCycles(group)
+					if err != nil {
+						log.Fatal(err)
+					}
+
+					if len(confs) > 1 || len(cycles) > 1 {
+						reporter.Log(fmt.Sprintf("report for %s at cycles %v", c.Delegate, group))
+					}
+					reporter.PrintPaymentsTable(payouts)
+					reporter.WriteCSVReport(payouts)
+					reports = append(reports, payouts)
+				}
+			}
+
+			if len(reports) > 1 {
+				reporter.PrintDelegatesSummaryTable(reports)
+			}
 
 			f.Close()
 		},
 	}
 
-	report.PersistentFlags().StringVarP(&conf.Delegate, "delegate", "d", "", "public key hash of the delegate that's paying out (e.g. --delegate=<phk>)")
-	report.PersistentFlags().IntVarP(&conf.Cycle, "cycle", "c", 0, "cycle to payout for (e.g. 95)")
-	report.PersistentFlags().StringVarP(&conf.URL, "node", "u", "http://127.0.0.1:8732", "address to the node to query (default http://127.0.0.1:8732)(e.g. https://mainnet-node.tzscan.io:443)")
-	report.PersistentFlags().Float32VarP(&conf.Fee, "fee", "f", -1, "fee for the delegate (e.g. 0.05 = 5%)")
-	report.PersistentFlags().IntVar(&conf.PaymentMinimum, "payout-min", 0, "will only payout to addresses that meet the payout minimum (e.g. --payout-min=<mutez>)")
-	report.PersistentFlags().StringVarP(&conf.File, "log-file", "l", "/dev/stdout", "file to log to (default stdout)(e.g. ./payman.log)")
+	reportFlags(report.PersistentFlags(), &conf)
 
 	return report
 }
+
+// reportFlags registers the flags of the report command on flags, bound to conf
+func reportFlags(flags *pflag.FlagSet, conf *options.Options) {
+	flags.StringVarP(&conf.Delegate, "delegate", "d", "", "public key hash of the delegate that's paying out, or with --delegates the only delegate in the file to report on (e.g. --delegate=<phk>)")
+	flags.StringVar(&conf.Delegates.File, "delegates", "", "reports on every delegate in json <file>, each with its own fee, fee schedule, blacklist, redirects and payout minimum (e.g. path/to/my/file/delegates.json)")
+	flags.IntVarP(&conf.Cycle, "cycle", "c", 0, "cycle to payout for (e.g. 95)")
+	flags.IntVar(&conf.CycleFrom, "cycle-from", 0, "first cycle of a range of cycles to report on in order, or with --backfill the first cycle to look for unpaid cycles from (e.g. 95)")
+	flags.IntVar(&conf.CycleTo, "cycle-to", 0, "last cycle of a range of cycles to report on, or with --backfill the last cycle to look for unpaid cycles up to (default latest payable cycle)(e.g. 98)")
+	flags.BoolVar(&conf.Backfill, "backfill", false, "report on every cycle the ledger does not show as paid, from the first cycle paid in the ledger up to the latest payable cycle (default false)(e.g. --backfill)")
+	flags.BoolVar(&conf.Merge, "merge", false, "merge the payments of every cycle reported on into one payout, as payout --merge would pay them (default false)(e.g. --merge)")
+	flags.StringVarP(&conf.URL, "node", "u", "http://127.0.0.1:8732", "address to the node to query (default http://127.0.0.1:8732)(e.g. https://mainnet-node.tzscan.io:443)")
+	flags.StringVarP(&conf.Fee, "fee", "f", "", "fee for the delegate as an exact decimal or fraction (e.g. 0.05 = 5%)")
+	flags.StringVar(&conf.FeeSchedule.File, "fee-schedule", "", "charges the fee rates in json <file> to the addresses listed, and its default rate to everyone else (e.g. path/to/my/file/fees.json)")
+	flags.StringVar(&conf.Remainder, "remainder", options.RemainderBaker, "who gets the mutez left over from rounding every share down, baker or delegators (default baker)(e.g. --remainder=delegators)")
+	flags.IntVar(&conf.PaymentMinimum, "payout-min", 0, "will only payout to addresses that meet the payout minimum (e.g. --payout-min=<mutez>)")
+	flags.StringVar(&conf.BlacklistFile, "blacklist", "", "will not pay out to addresses in json <file> (string array)")
+	flags.StringVar(&conf.Redirects.File, "redirects", "", "pays the rewards of delegations to the addresses they map to in json <file> (e.g. path/to/my/file/redirects.json)")
+	flags.BoolVar(&conf.CarryOver, "carry-over", false, "add the rewards carried over in the ledger to each payout, and defer payouts still under the payout minimum (default false)(e.g. --carry-over)")
+	flags.StringVar(&conf.Ledger, "ledger", "payman.ledger.json", "file recording every payout made, used to find the rewards carried over and the cycles to backfill (default payman.ledger.json)(e.g. path/to/my/file/ledger.json)")
+	flags.StringVarP(&conf.File, "log-file", "l", "/dev/stdout", "file to log to (default stdout)(e.g. ./payman.log)")
+}
+
+// reportPreflight checks the options of every delegate to report on, and returns the errors that stop the report
+func reportPreflight(confs []options.Options) []string {
+	errors := []string{}
+	for _, conf := range confs {
+		if conf.Delegate == "" {
+			errors = append(errors, "[payout][preflight] error: no delegate passed for payout (e.g. --delegate=<pkh>)")
+		}
+		if conf.Fee == "" && conf.FeeSchedule.File == "" {
+			errors = append(errors, "[payout][preflight] error: no delegation fee passed for payout (e.g. --fee=0.05)")
+		}
+		if _, err := options.ParseRate(conf.Fee); conf.Fee != "" && err != nil {
+			errors = append(errors, fmt.Sprintf("[payout][preflight] error: %v (e.g. --fee=0.05)", err))
+		}
+	}
+
+	conf := confs[0]
+	if conf.Cycle == 0 && conf.CycleFrom == 0 && !conf.Backfill {
+		errors = append(errors, "[payout][preflight] error: no cycle passed to payout for (e.g. --cycle=95)")
+	}
+	if conf.Remainder != options.RemainderBaker && conf.Remainder != options.RemainderDelegators {
+		errors = append(errors, "[payout][preflight] error: remainder must be baker or delegators (e.g. --remainder=baker)")
+	}
+	errors = append(errors, cyclesPreflight(conf)...)
+	errors = append(errors, addressesPreflight(confs)...)
+
+	return errors
+}
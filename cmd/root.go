@@ -10,9 +10,14 @@ func newRootCommand() *cobra.Command {
 		Short: "A bulk payout tool for bakers in the Tezos Ecosystem",
 	}
 
+	rootCommand.PersistentFlags().String("config", "", "read options from a yaml, toml or json <file>, options passed as flags take precedence (e.g. path/to/my/file/payman.yml)")
+
 	rootCommand.AddCommand(
 		newPayoutCommand(),
 		newReportCommand(),
+		newConfigCommand(),
+		newWalletCommand(),
+		newSignCommand(),
 	)
 
 	return rootCommand
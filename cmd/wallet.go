@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	goTezos "github.com/DefinitelyNotAGoat/go-tezos"
+	"github.com/DefinitelyNotAGoat/payman/keystore"
+	"github.com/DefinitelyNotAGoat/payman/options"
+	pay "github.com/DefinitelyNotAGoat/payman/payer"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+func newWalletCommand() *cobra.Command {
+	var file string
+
+	var wallet = &cobra.Command{
+		Use:   "wallet",
+		Short: "wallet manages the encrypted keystore of a payout wallet",
+	}
+
+	var importWallet = &cobra.Command{
+		Use:   "import",
+		Short: "import stores a secret key (edesk or edsk) in a new keystore, encrypted with a password",
+		Run: func(cmd *cobra.Command, args []string) {
+			if _, err := os.Stat(file); err == nil {
+				exit(fmt.Errorf("keystore %s already exists", file))
+			}
+
+			secret, err := prompt("secret key (edesk or edsk): ")
+			if err != nil {
+				exit(err)
+			}
+
+			var password string
+			if len(secret) > 5 && secret[:5] == "edesk" {
+				password, err = prompt("password of the encrypted secret key: ")
+			} else {
+				password, err = newPassword()
+			}
+			if err != nil {
+				exit(err)
+			}
+
+			k, err := keystore.New(secret, password)
+			if err != nil {
+				exit(err)
+			}
+			if err = k.Write(file); err != nil {
+				exit(err)
+			}
+
+			fmt.Printf("imported %s into %s\n", k.Address, file)
+		},
+	}
+
+	var address = &cobra.Command{
+		Use:   "address",
+		Short: "address shows the address of the wallet in a keystore",
+		Run: func(cmd *cobra.Command, args []string) {
+			k, err := keystore.Read(file)
+			if err != nil {
+				exit(err)
+			}
+
+			fmt.Println(k.Address)
+		},
+	}
+
+	var changePassword = &cobra.Command{
+		Use:   "change-password",
+		Short: "change-password re-encrypts the secret key in a keystore with a new password",
+		Run: func(cmd *cobra.Command, args []string) {
+			k, err := keystore.Read(file)
+			if err != nil {
+				exit(err)
+			}
+
+			old, err := prompt(fmt.Sprintf("current password for %s: ", k.Address))
+			if err != nil {
+				exit(err)
+			}
+			new, err := newPassword()
+			if err != nil {
+				exit(err)
+			}
+
+			if err = k.ChangePassword(old, new); err != nil {
+				exit(err)
+			}
+			if err = k.Write(file); err != nil {
+				exit(err)
+			}
+
+			fmt.Printf("changed the password of %s in %s\n", k.Address, file)
+		},
+	}
+
+	wallet.AddCommand(importWallet, address, changePassword, newRevealCommand(&file))
+	wallet.PersistentFlags().StringVar(&file, "keystore", "payman.keystore.json", "keystore file to manage (default payman.keystore.json)(e.g. path/to/my/file/keystore.json)")
+	return wallet
+}
+
+func newRevealCommand(file *string) *cobra.Command {
+	var conf options.Options
+
+	var reveal = &cobra.Command{
+		Use:   "reveal",
+		Short: "reveal reveals the public key of a payout wallet that never sent an operation, so payouts from it need no reveal",
+		Run: func(cmd *cobra.Command, args []string) {
+			if conf.Secret == "" && conf.SecretFile == "" && conf.Signer == "" {
+				conf.Keystore = *file
+			}
+			errors := walletPreflight(conf)
+			if conf.SimulationMargin < 0 {
+				errors = append(errors, "[payout][preflight] error: simulation margin cannot be negative (e.g. --simulation-margin=0.1)")
+			}
+			printPreflight(errors, nil)
+
+			var err error
+			if conf.Signer == "" && conf.Password == "" && conf.PasswordFile == "" {
+				if conf.Password, err = prompt("password of the wallet to reveal: "); err != nil {
+					exit(err)
+				}
+			}
+
+			s, err := newSigner(&conf)
+			if err != nil {
+				exit(err)
+			}
+
+			gt, err := goTezos.NewGoTezos(conf.URL)
+			if err != nil {
+				exit(fmt.Errorf("could not connect to network: %v", err))
+			}
+
+			payer := pay.NewPayer(gt, s, nil, &conf)
+			resp, fee, err := payer.Reveal()
+			if err != nil {
+				exit(err)
+			}
+
+			fmt.Printf("revealed %s for a fee of %d mutez in %s\n", s.Address(), fee, strings.Trim(strings.TrimSpace(string(resp)), "\""))
+		},
+	}
+
+	flags := reveal.Flags()
+	flags.StringVarP(&conf.URL, "node", "u", "http://127.0.0.1:8732", "address to the node to query (default http://127.0.0.1:8732)(e.g. https://mainnet-node.tzscan.io:443)")
+	flags.StringVarP(&conf.Secret, "secret", "s", "", "encrypted secret key of the wallet to reveal, instead of the keystore (e.g. --secret=<sk>)")
+	flags.StringVar(&conf.SecretFile, "secret-file", "", "read the encrypted secret key of the wallet to reveal from <file>, which only its owner may access (e.g. path/to/my/file/secret)")
+	flags.StringVarP(&conf.Password, "password", "k", "", "password to the secret key of the wallet to reveal, prompted for if not passed (e.g. --password=<passwd>)")
+	flags.StringVar(&conf.PasswordFile, "password-file", "", "read the password to the secret key of the wallet to reveal from <file>, which only its owner may access (e.g. path/to/my/file/password)")
+	flags.StringVar(&conf.Signer, "signer", "", "reveal a key held by a remote signer instead of a secret key (e.g. http://localhost:6732/<pkh>)")
+	flags.IntVar(&conf.NetworkFee, "network-fee", 1270, "network fee of the reveal in mutez, when simulation is off (default 1270)(e.g. 2000)")
+	flags.IntVar(&conf.NetworkGasLimit, "gas-limit", 10200, "network gas limit of the reveal, when simulation is off (default 10200)(e.g. 10300)")
+	flags.BoolVar(&conf.Simulate, "simulate", true, "simulate the reveal on the node to set its gas limit and minimal fee (default true)(e.g. --simulate=false)")
+	flags.Float64Var(&conf.SimulationMargin, "simulation-margin", 0.1, "fraction added to the simulated gas of the reveal as a safety margin (default 0.1)(e.g. 0.2 = 20%)")
+	return reveal
+}
+
+// prompt asks for a secret on the terminal without echoing it
+func prompt(msg string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return "", fmt.Errorf("could not prompt for a secret: stdin is not a terminal")
+	}
+
+	fmt.Fprint(os.Stderr, msg)
+	secret, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("could not read from terminal: %v", err)
+	}
+	return string(secret), nil
+}
+
+// newPassword asks for a new password twice on the terminal, and checks both match
+func newPassword() (string, error) {
+	password, err := prompt("new password: ")
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", fmt.Errorf("password cannot be empty")
+	}
+
+	confirm, err := prompt("confirm new password: ")
+	if err != nil {
+		return "", err
+	}
+	if confirm != password {
+		return "", fmt.Errorf("passwords do not match")
+	}
+	return password, nil
+}
+
+// canPrompt returns true if payman can prompt for a password on the terminal
+func canPrompt() bool {
+	return terminal.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// exit prints the error and exits
+func exit(err error) {
+	fmt.Println(err)
+	os.Exit(1)
+}
@@ -4,15 +4,19 @@ go 1.12
 
 require (
 	github.com/DefinitelyNotAGoat/go-tezos v1.0.6
+	github.com/Messer4/base58check v0.0.0-20180328134002-7531a92ae9ba
 	github.com/cenkalti/backoff v2.1.1+incompatible // indirect
 	github.com/dghubble/go-twitter v0.0.0-20190512073027-53f972dc4b06
 	github.com/dghubble/oauth1 v0.5.0
 	github.com/dghubble/sling v1.2.0 // indirect
 	github.com/google/go-querystring v1.0.0 // indirect
 	github.com/mattn/go-runewidth v0.0.4 // indirect
+	github.com/mitchellh/mapstructure v1.1.2
 	github.com/olekukonko/tablewriter v0.0.1
 	github.com/spf13/cobra v0.0.4
+	github.com/spf13/pflag v1.0.3
 	github.com/spf13/viper v1.4.0
 	github.com/turnage/graw v0.0.0-20190218184947-3295929039f6
 	github.com/turnage/redditproto v0.0.0-20151223012412-afedf1b6eddb // indirect
+	golang.org/x/crypto v0.0.0-20190426145343-a29dc8fdc734
 )
@@ -0,0 +1,175 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/DefinitelyNotAGoat/payman/atomicfile"
+	"github.com/Messer4/base58check"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// version is the version of the keystore file format
+const version = 1
+
+var (
+	// prefixes of the base58check encodings of keys and addresses
+	edesk = []byte{7, 90, 60, 179, 41}
+	edsk  = []byte{43, 246, 78, 7}
+	edsk2 = []byte{13, 15, 58, 7}
+	tz1   = []byte{6, 161, 159}
+)
+
+// Keystore is a wallet's encrypted secret key stored on disk with the address it belongs to,
+// so the address can be shown without the password
+type Keystore struct {
+	Version int    `json:"version"`
+	Address string `json:"address"`
+	Secret  string `json:"secret"`
+}
+
+// New returns a keystore for the secret key, an encrypted secret key (edesk) that is checked
+// against the password, or an unencrypted secret key or seed (edsk) that is encrypted with it
+func New(secret, password string) (*Keystore, error) {
+	var seed []byte
+	var err error
+	if strings.HasPrefix(secret, "edesk") {
+		seed, err = Decrypt(secret, password)
+	} else {
+		seed, err = decodeSeed(secret)
+		if err == nil {
+			secret, err = Encrypt(seed, password)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Keystore{Version: version, Address: address(seed), Secret: secret}, nil
+}
+
+// Read reads the keystore stored in file
+func Read(file string) (*Keystore, error) {
+	byteValue, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read keystore %s: %v", file, err)
+	}
+
+	var k Keystore
+	if err = json.Unmarshal(byteValue, &k); err != nil {
+		return nil, fmt.Errorf("could not unmarshal keystore %s: %v", file, err)
+	}
+	if k.Version != version {
+		return nil, fmt.Errorf("could not read keystore %s: unsupported version %d", file, k.Version)
+	}
+	if !strings.HasPrefix(k.Secret, "edesk") {
+		return nil, fmt.Errorf("could not read keystore %s: secret key is not encrypted", file)
+	}
+
+	return &k, nil
+}
+
+// Write writes the keystore to file, readable only by its owner, through a temporary file so a crash
+// cannot lose the secret key
+func (k *Keystore) Write(file string) error {
+	byteValue, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal keystore: %v", err)
+	}
+
+	if err = atomicfile.Write(file, byteValue); err != nil {
+		return fmt.Errorf("could not write keystore %s: %v", file, err)
+	}
+	return nil
+}
+
+// ChangePassword re-encrypts the keystore's secret key with a new password
+func (k *Keystore) ChangePassword(old, new string) error {
+	seed, err := Decrypt(k.Secret, old)
+	if err != nil {
+		return err
+	}
+
+	secret, err := Encrypt(seed, new)
+	if err != nil {
+		return err
+	}
+
+	k.Secret = secret
+	return nil
+}
+
+// Encrypt encrypts a 32 byte ed25519 seed with the password into an encrypted secret key (edesk),
+// the same way tezos-client does
+func Encrypt(seed []byte, password string) (string, error) {
+	if len(seed) != ed25519.SeedSize {
+		return "", fmt.Errorf("could not encrypt secret key: invalid seed length %d", len(seed))
+	}
+
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("could not encrypt secret key: %v", err)
+	}
+
+	var nonce [24]byte
+	sealed := secretbox.Seal(nil, seed, &nonce, key(password, salt))
+
+	data := append(append(append([]byte{}, edesk...), salt...), sealed...)
+	return base58check.Encode(data), nil
+}
+
+// Decrypt decrypts an encrypted secret key (edesk) with the password and returns its ed25519 seed
+func Decrypt(secret, password string) ([]byte, error) {
+	data, err := base58check.Decode(secret)
+	if err != nil || !bytes.HasPrefix(data, edesk) || len(data) != len(edesk)+8+ed25519.SeedSize+secretbox.Overhead {
+		return nil, fmt.Errorf("could not decrypt secret key: not an encrypted secret key")
+	}
+
+	data = data[len(edesk):]
+	var nonce [24]byte
+	seed, ok := secretbox.Open(nil, data[8:], &nonce, key(password, data[:8]))
+	if !ok {
+		return nil, fmt.Errorf("could not decrypt secret key: invalid password")
+	}
+
+	return seed, nil
+}
+
+// key derives the secretbox key of an encrypted secret key from the password and salt
+func key(password string, salt []byte) *[32]byte {
+	var k [32]byte
+	copy(k[:], pbkdf2.Key([]byte(password), salt, 32768, 32, sha512.New))
+	return &k
+}
+
+// decodeSeed returns the ed25519 seed of an unencrypted secret key or seed (edsk)
+func decodeSeed(secret string) ([]byte, error) {
+	data, err := base58check.Decode(secret)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode secret key: %v", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(data, edsk) && len(data) == len(edsk)+ed25519.PrivateKeySize:
+		return data[len(edsk) : len(edsk)+ed25519.SeedSize], nil
+	case bytes.HasPrefix(data, edsk2) && len(data) == len(edsk2)+ed25519.SeedSize:
+		return data[len(edsk2):], nil
+	}
+	return nil, fmt.Errorf("could not decode secret key: not an ed25519 secret key (edsk or edesk)")
+}
+
+// address returns the tz1 address of the ed25519 seed
+func address(seed []byte) string {
+	public := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+	hash, _ := blake2b.New(20, nil)
+	hash.Write(public)
+	return base58check.Encode(append(append([]byte{}, tz1...), hash.Sum(nil)...))
+}
@@ -0,0 +1,352 @@
+package ledger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	goTezos "github.com/DefinitelyNotAGoat/go-tezos"
+	"github.com/DefinitelyNotAGoat/payman/atomicfile"
+)
+
+// Status describes the state of a payout recorded in the ledger
+type Status string
+
+const (
+	// StatusPending is a payout that has been recorded but not forged
+	StatusPending Status = "pending"
+	// StatusForged is a payout that has been forged and signed but not injected
+	StatusForged Status = "forged"
+	// StatusInjected is a payout that has been accepted by the node's injection endpoint
+	StatusInjected Status = "injected"
+	// StatusConfirmed is a payout that was included on chain and has enough confirmations
+	StatusConfirmed Status = "confirmed"
+	// StatusFailed is a payout that could not be forged or injected, or was not applied on chain
+	StatusFailed Status = "failed"
+	// StatusDropped is a payout that was injected but never included on chain
+	StatusDropped Status = "dropped"
+)
+
+// Entry is the record of a payout for a single delegate and cycle. A payout that merged several
+// cycles lists them in Merged, and is recorded under each of them. Payouts of the cycle that were
+// paid before a forced payout replaced them are kept in History, oldest first.
+type Entry struct {
+	Delegate string
+	Cycle    int
+	Merged   []int `json:",omitempty"`
+	Batches  []Batch
+	Skipped  []Skipped        `json:",omitempty"`
+	Carry    map[string]int64 `json:",omitempty"`
+	Created  time.Time
+	Updated  time.Time
+	History  []Entry `json:",omitempty"`
+}
+
+// Batch is the record of a single operation in a payout, and the payments it contains
+type Batch struct {
+	Payments      []Payment
+	Operation     string `json:",omitempty"`
+	OpHash        string `json:",omitempty"`
+	InjectedLevel int    `json:",omitempty"`
+	IncludedLevel int    `json:",omitempty"`
+	RevealFee     int64  `json:",omitempty"`
+	Status        Status
+	Error         string `json:",omitempty"`
+}
+
+// Payment is a single transfer in a batch. Delegation is the address that earned the rewards,
+// if they were redirected to be paid to another address.
+type Payment struct {
+	Address    string
+	Amount     float64
+	Delegation string `json:",omitempty"`
+}
+
+// Skipped is a payment left out of a payout because the node refused it, and the node's reason,
+// for the payment to be followed up by hand
+type Skipped struct {
+	Payment
+	Reason string
+}
+
+// Payment converts the ledger payment into a go-tezos payment for batch pay
+func (p Payment) Payment() goTezos.Payment {
+	return goTezos.Payment{Address: p.Address, Amount: p.Amount}
+}
+
+// Paid returns true if any of the entry's operations have already reached the network
+func (e *Entry) Paid() bool {
+	for _, batch := range e.Batches {
+		if batch.Injected() {
+			return true
+		}
+	}
+	return false
+}
+
+// Complete returns true if every operation in the entry has reached the network
+func (e *Entry) Complete() bool {
+	for _, batch := range e.Batches {
+		if !batch.Injected() {
+			return false
+		}
+	}
+	return len(e.Batches) > 0
+}
+
+// Confirmed returns true if every operation in the entry was included on chain and confirmed
+func (e *Entry) Confirmed() bool {
+	for _, batch := range e.Batches {
+		if batch.Status != StatusConfirmed {
+			return false
+		}
+	}
+	return len(e.Batches) > 0
+}
+
+// OpHashes returns the hashes of every operation in the entry that reached the network
+func (e *Entry) OpHashes() []string {
+	hashes := []string{}
+	for _, batch := range e.Batches {
+		if batch.OpHash != "" {
+			hashes = append(hashes, batch.OpHash)
+		}
+	}
+	return hashes
+}
+
+// RevealFee returns the fee paid to reveal the paying wallet with the entry's operations that reached
+// the network, or 0 if none revealed it
+func (e *Entry) RevealFee() int64 {
+	var fee int64
+	for _, batch := range e.Batches {
+		if batch.Injected() {
+			fee += batch.RevealFee
+		}
+	}
+	return fee
+}
+
+// Total returns the sum of every payment in the entry in mutez
+func (e *Entry) Total() int64 {
+	var total int64
+	for _, batch := range e.Batches {
+		for _, payment := range batch.Payments {
+			total += int64(math.Round(payment.Amount))
+		}
+	}
+	return total
+}
+
+// Injected returns true if the batch's operation has reached the network and was not dropped or failed
+func (b *Batch) Injected() bool {
+	return b.Status == StatusInjected || b.Status == StatusConfirmed
+}
+
+// Ledger is an on disk record of every payout made by payman, keyed by delegate and cycle
+type Ledger struct {
+	mu      sync.Mutex
+	file    string
+	Entries map[string]*Entry
+}
+
+// Open reads the ledger stored in file, or creates an empty ledger if the file does not exist yet
+func Open(file string) (*Ledger, error) {
+	l := &Ledger{file: file, Entries: make(map[string]*Entry)}
+
+	byteValue, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return l, nil
+	} else if err != nil {
+		return l, fmt.Errorf("could not read ledger %s: %v", file, err)
+	}
+
+	err = json.Unmarshal(byteValue, l)
+	if err != nil {
+		return l, fmt.Errorf("could not unmarshal ledger %s: %v", file, err)
+	}
+	if l.Entries == nil {
+		l.Entries = make(map[string]*Entry)
+	}
+
+	return l, nil
+}
+
+// Get returns a copy of the entry for the delegate and cycle, or nil if nothing was recorded
+func (l *Ledger) Get(delegate string, cycle int) *Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.Entries[key(delegate, cycle)]
+	if !ok {
+		return nil
+	}
+	return entry.copy()
+}
+
+// LastPaid returns the highest cycle the ledger shows as paid for the delegate, or -1 if none
+func (l *Ledger) LastPaid(delegate string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	last := -1
+	for _, entry := range l.Entries {
+		if entry.Delegate == delegate && entry.Paid() && entry.Cycle > last {
+			last = entry.Cycle
+		}
+	}
+	return last
+}
+
+// Previous returns a copy of the entry of the latest cycle before cycle that the ledger shows as paid
+// for the delegate, or nil if none
+func (l *Ledger) Previous(delegate string, cycle int) *Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var previous *Entry
+	for _, entry := range l.Entries {
+		if entry.Delegate == delegate && entry.Paid() && entry.Cycle < cycle && (previous == nil || entry.Cycle > previous.Cycle) {
+			previous = entry
+		}
+	}
+	if previous == nil {
+		return nil
+	}
+	return previous.copy()
+}
+
+// FirstPaid returns the lowest cycle the ledger shows as paid for the delegate, or -1 if none
+func (l *Ledger) FirstPaid(delegate string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	first := -1
+	for _, entry := range l.Entries {
+		if entry.Delegate == delegate && entry.Paid() && (first == -1 || entry.Cycle < first) {
+			first = entry.Cycle
+		}
+	}
+	return first
+}
+
+// Unpaid returns every cycle from from to to, in order, that the ledger does not show as paid for the delegate
+func (l *Ledger) Unpaid(delegate string, from, to int) []int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cycles := []int{}
+	for cycle := from; cycle <= to; cycle++ {
+		if entry, ok := l.Entries[key(delegate, cycle)]; ok && entry.Paid() {
+			continue
+		}
+		cycles = append(cycles, cycle)
+	}
+	return cycles
+}
+
+// Carried returns the rewards in mutez carried over for address from the delegate's paid cycles other
+// than cycle. Each entry's Carry records the rewards it carried over to later cycles as a positive
+// amount, and the carried rewards it paid out as a negative amount. Entries that had payments but
+// never reached the network are not counted, and merged entries are only counted once.
+func (l *Ledger) Carried(delegate, address string, cycle int) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var carried int64
+	for _, entry := range l.Entries {
+		if entry.Delegate != delegate || entry.Cycle == cycle || (!entry.Paid() && len(entry.Batches) > 0) {
+			continue
+		}
+		if len(entry.Merged) > 0 && (entry.Merged[0] != entry.Cycle || merges(entry, cycle)) {
+			continue
+		}
+		carried += entry.Carry[address]
+	}
+	return carried
+}
+
+// Put records the entry, under every cycle it merged if any, and writes the ledger to disk. A paid
+// payout recorded for a cycle that was created before the entry, as when a payout is forced, is
+// moved into the entry's history rather than overwritten.
+func (l *Ledger) Put(entry *Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cycles := entry.Merged
+	if len(cycles) == 0 {
+		cycles = []int{entry.Cycle}
+	}
+
+	updated := time.Now().UTC()
+	for _, cycle := range cycles {
+		cp := entry.copy()
+		cp.Cycle = cycle
+		cp.Updated = updated
+		if old, ok := l.Entries[key(entry.Delegate, cycle)]; ok {
+			cp.History = old.History
+			if !old.Created.Equal(entry.Created) && old.Paid() {
+				superseded := *old.copy()
+				superseded.History = nil
+				cp.History = append(append([]Entry{}, old.History...), superseded)
+			}
+		}
+		l.Entries[key(entry.Delegate, cycle)] = cp
+	}
+	return l.save()
+}
+
+// save writes the ledger over its file, through a temporary file so a crash can never leave a half
+// written ledger behind
+func (l *Ledger) save() error {
+	byteValue, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal ledger: %v", err)
+	}
+
+	if err = atomicfile.Write(l.file, byteValue); err != nil {
+		return fmt.Errorf("could not write ledger %s: %v", l.file, err)
+	}
+	return nil
+}
+
+// copy returns a copy of the entry that shares no batches with the original
+func (e *Entry) copy() *Entry {
+	cp := *e
+	cp.Batches = make([]Batch, len(e.Batches))
+	copy(cp.Batches, e.Batches)
+	if e.Merged != nil {
+		cp.Merged = make([]int, len(e.Merged))
+		copy(cp.Merged, e.Merged)
+	}
+	if e.Carry != nil {
+		cp.Carry = make(map[string]int64, len(e.Carry))
+		for address, amount := range e.Carry {
+			cp.Carry[address] = amount
+		}
+	}
+	if e.History != nil {
+		cp.History = make([]Entry, len(e.History))
+		copy(cp.History, e.History)
+	}
+	return &cp
+}
+
+// merges returns true if the entry is a merged payout that includes cycle
+func merges(entry *Entry, cycle int) bool {
+	for _, merged := range entry.Merged {
+		if merged == cycle {
+			return true
+		}
+	}
+	return false
+}
+
+func key(delegate string, cycle int) string {
+	return delegate + "/" + strconv.Itoa(cycle)
+}
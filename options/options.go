@@ -2,26 +2,49 @@ package options
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"math"
+	"math/big"
 	"os"
+	"strings"
 
 	goTezos "github.com/DefinitelyNotAGoat/go-tezos"
+	"github.com/DefinitelyNotAGoat/payman/address"
+	"github.com/DefinitelyNotAGoat/payman/keystore"
 )
 
 // Options is a struct to represent configuration options for payman
 type Options struct {
 	Delegate         string
 	Secret           string
+	SecretFile       string
+	Keystore         string
 	Password         string
+	PasswordFile     string
+	Signer           string
 	Service          bool
 	Cycle            int
+	CycleFrom        int
+	CycleTo          int
+	Backfill         bool
+	Merge            bool
 	URL              string
-	Fee              float32
+	Fee              string
 	File             string
 	NetworkFee       int
 	NetworkGasLimit  int
+	Simulate         bool
+	SimulationMargin float64
+	BatchSize        int
 	PaymentMinimum   int
+	MaxTotal         int64
+	MaxPayment       int64
+	MaxDeviation     float64
+	OverrideLimits   bool
+	Underfunded      string
 	Blacklist        []string
+	BlacklistFile    string
 	Dry              bool
 	RedditAgent      string
 	RedditTitle      string
@@ -29,32 +52,486 @@ type Options struct {
 	TwitterTitle     string
 	Twitter          bool
 	PaymentsOverride PaymentsOverride
+	Ledger           string
+	Force            bool
+	Resume           bool
+	Confirmations    int
+	CycleOffset      int
+	Remainder        string
+	FeeSchedule      FeeSchedule
+	Redirects        Redirects
+	CarryOver        bool
+	Delegates        Delegates
+}
+
+const (
+	// RemainderBaker assigns the mutez left over from rounding every delegation's share down to the baker
+	RemainderBaker = "baker"
+	// RemainderDelegators distributes the mutez left over from rounding, one each, to the
+	// delegations whose shares lost the most to rounding
+	RemainderDelegators = "delegators"
+)
+
+const (
+	// UnderfundedRefuse refuses to start a payout the paying wallet cannot afford
+	UnderfundedRefuse = "refuse"
+	// UnderfundedPartial pays the payouts the paying wallet can afford, smallest first, and
+	// carries the rest over to a later cycle
+	UnderfundedPartial = "partial"
+)
+
+// ReadFiles reads in the blacklist, redirects and fee schedule files the options point to
+func (o *Options) ReadFiles() error {
+	var err error
+	if o.BlacklistFile != "" {
+		o.Blacklist, err = ReadBlacklist(o.BlacklistFile)
+		if err != nil {
+			return fmt.Errorf("could not read in blacklist %s: %v", o.BlacklistFile, err)
+		}
+	}
+
+	if o.Redirects.File != "" {
+		if err = o.Redirects.ReadRedirects(); err != nil {
+			return fmt.Errorf("could not read in redirects %s: %v", o.Redirects.File, err)
+		}
+	}
+
+	if o.FeeSchedule.File != "" {
+		if err = o.FeeSchedule.ReadFeeSchedule(); err != nil {
+			return fmt.Errorf("could not read in fee schedule %s: %v", o.FeeSchedule.File, err)
+		}
+		if o.FeeSchedule.Default == "" && o.Fee == "" {
+			return fmt.Errorf("fee schedule %s has no default rate, pass one with --fee (e.g. --fee=0.05)", o.FeeSchedule.File)
+		}
+	}
+
+	return nil
+}
+
+// ReadSecrets reads the payout wallet's secret key from the secret file or keystore, and its password
+// from the password file, unless they were passed directly. Secret and password files must not be
+// readable by other users. Nothing is read for a wallet signed for by a remote signer.
+func (o *Options) ReadSecrets() error {
+	if o.Signer != "" {
+		return nil
+	}
+
+	var err error
+	if o.Secret == "" && o.SecretFile != "" {
+		o.Secret, err = readSecretFile(o.SecretFile)
+		if err != nil {
+			return err
+		}
+		if o.Secret == "" {
+			return fmt.Errorf("could not read secret file %s: no secret key on its first line", o.SecretFile)
+		}
+	}
+
+	if o.Secret == "" && o.Keystore != "" {
+		k, err := keystore.Read(o.Keystore)
+		if err != nil {
+			return err
+		}
+		o.Secret = k.Secret
+	}
+
+	if o.Password == "" && o.PasswordFile != "" {
+		o.Password, err = readSecretFile(o.PasswordFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readSecretFile reads a secret from the first line of file, refusing files other users can read or write
+func readSecretFile(file string) (string, error) {
+	if err := CheckPrivate(file); err != nil {
+		return "", fmt.Errorf("could not read secret file %s: %v", file, err)
+	}
+
+	byteValue, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("could not read secret file %s: %v", file, err)
+	}
+
+	return strings.TrimRight(strings.SplitN(string(byteValue), "\n", 2)[0], "\r"), nil
+}
+
+// CheckPrivate returns an error if other users can read or write file, which must then not hold secrets
+func CheckPrivate(file string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("other users have access to it (mode %04o), restrict it with chmod 600", info.Mode().Perm())
+	}
+	return nil
+}
+
+// ForDelegates returns a copy of the options for every delegate listed, with the delegate's own
+// settings in place of the ones passed. If no delegates are listed, it returns the options as they
+// are. If a delegate was passed as well, only that delegate is returned.
+func (o Options) ForDelegates() ([]Options, error) {
+	if len(o.Delegates.Delegates) == 0 {
+		return []Options{o}, nil
+	}
+
+	confs := []Options{}
+	for _, d := range o.Delegates.Delegates {
+		if o.Delegate != "" && d.Delegate != o.Delegate {
+			continue
+		}
+		confs = append(confs, d.apply(o))
+	}
+	if len(confs) == 0 {
+		return confs, fmt.Errorf("delegate %s is not in the delegates listed", o.Delegate)
+	}
+	return confs, nil
+}
+
+// Delegates is a configuration option to pay out several delegates from a single invocation, listed
+// in a delegates file or in the config file
+type Delegates struct {
+	File      string
+	Delegates []Delegate
+}
+
+// Delegate is the configuration of one delegate in a delegates file, any setting left out falls back
+// to the one passed on the command line
+type Delegate struct {
+	Delegate       string `json:"delegate"`
+	Secret         string `json:"secret"`
+	SecretFile     string `json:"secret_file"`
+	Keystore       string `json:"keystore"`
+	Password       string `json:"password"`
+	PasswordFile   string `json:"password_file"`
+	Signer         string `json:"signer"`
+	Fee            string `json:"fee"`
+	FeeSchedule    string `json:"fee_schedule"`
+	Blacklist      string `json:"blacklist"`
+	Redirects      string `json:"redirects"`
+	PaymentMinimum *int   `json:"payout_min"`
+	MaxTotal       *int64 `json:"max_total"`
+	MaxPayment     *int64 `json:"max_payment"`
+}
+
+// apply returns a copy of conf with the delegate's settings in place of the ones passed
+func (d Delegate) apply(conf Options) Options {
+	conf.Delegate = d.Delegate
+	if d.Secret != "" || d.SecretFile != "" || d.Keystore != "" || d.Signer != "" {
+		conf.Secret, conf.SecretFile, conf.Keystore, conf.Signer = d.Secret, d.SecretFile, d.Keystore, d.Signer
+	}
+	if d.Password != "" || d.PasswordFile != "" {
+		conf.Password, conf.PasswordFile = d.Password, d.PasswordFile
+	}
+	if d.Fee != "" {
+		conf.Fee = d.Fee
+	}
+	if d.FeeSchedule != "" {
+		conf.FeeSchedule = FeeSchedule{File: d.FeeSchedule}
+	}
+	if d.Blacklist != "" {
+		conf.BlacklistFile = d.Blacklist
+	}
+	if d.Redirects != "" {
+		conf.Redirects = Redirects{File: d.Redirects}
+	}
+	if d.PaymentMinimum != nil {
+		conf.PaymentMinimum = *d.PaymentMinimum
+	}
+	if d.MaxTotal != nil {
+		conf.MaxTotal = *d.MaxTotal
+	}
+	if d.MaxPayment != nil {
+		conf.MaxPayment = *d.MaxPayment
+	}
+	return conf
+}
+
+// ReadDelegates reads a json array of delegates from the delegates file and checks them
+func (d *Delegates) ReadDelegates() error {
+	jsonFile, err := os.Open(d.File)
+	if err != nil {
+		return err
+	}
+	defer jsonFile.Close()
+
+	byteValue, err := ioutil.ReadAll(jsonFile)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(byteValue, &d.Delegates)
+	if err != nil {
+		return err
+	}
+
+	for _, delegate := range d.Delegates {
+		if delegate.Delegate != "" {
+			if err = address.CheckJSON(byteValue, delegate.Delegate); err != nil {
+				return err
+			}
+		}
+	}
+
+	if d.Secrets() {
+		if err = CheckPrivate(d.File); err != nil {
+			return fmt.Errorf("it holds secret keys or passwords and %v", err)
+		}
+	}
+
+	return d.Check()
+}
+
+// Secrets returns true if any delegate listed has its secret key or password inline
+func (d *Delegates) Secrets() bool {
+	for _, delegate := range d.Delegates {
+		if delegate.Secret != "" || delegate.Password != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Check checks that delegates are listed, each with a valid address and only once
+func (d *Delegates) Check() error {
+	if len(d.Delegates) == 0 {
+		return fmt.Errorf("no delegates listed")
+	}
+	seen := make(map[string]bool)
+	for i, delegate := range d.Delegates {
+		if delegate.Delegate == "" {
+			return fmt.Errorf("delegate %d: no delegate address", i)
+		}
+		if err := address.Check(delegate.Delegate); err != nil {
+			return fmt.Errorf("delegate %d: %v", i, err)
+		}
+		if seen[delegate.Delegate] {
+			return fmt.Errorf("delegate %s is listed more than once", delegate.Delegate)
+		}
+		seen[delegate.Delegate] = true
+	}
+
+	return nil
+}
+
+// ParseRate parses an exact decimal or fractional fee rate (e.g. 0.05 or 1/20) between 0 and 1
+func ParseRate(rate string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(rate)
+	if !ok {
+		return nil, fmt.Errorf("invalid fee rate '%s'", rate)
+	}
+	if r.Sign() < 0 || r.Cmp(big.NewRat(1, 1)) > 0 {
+		return nil, fmt.Errorf("invalid fee rate '%s', must be between 0 and 1", rate)
+	}
+	return r, nil
+}
+
+// FeeSchedule is a configuration option to charge delegations negotiated or balance tiered fee
+// rates instead of the single fee passed, rates are exact decimals or fractions like the fee
+type FeeSchedule struct {
+	File      string            `json:"-"`
+	Default   string            `json:"default"`
+	Addresses map[string]string `json:"addresses"`
+	Tiers     []FeeTier         `json:"tiers"`
+	History   []FeeChange       `json:"history"`
+}
+
+// FeeChange is a change of fee from a cycle onwards. A change with an address sets the rate charged
+// to that address. A change without one replaces the general rate, and the tiers if it has any, for
+// every delegation that is not charged a negotiated rate.
+type FeeChange struct {
+	Cycle   int       `json:"cycle"`
+	Rate    string    `json:"rate,omitempty"`
+	Tiers   []FeeTier `json:"tiers,omitempty"`
+	Address string    `json:"address,omitempty"`
+}
+
+// Change returns the latest change for address effective at cycle, or nil if there is none. An empty
+// address returns the latest change that applies to every delegation.
+func (f *FeeSchedule) Change(address string, cycle int) *FeeChange {
+	var latest *FeeChange
+	for i, change := range f.History {
+		if change.Address == address && change.Cycle <= cycle && (latest == nil || change.Cycle > latest.Cycle) {
+			latest = &f.History[i]
+		}
+	}
+	return latest
+}
+
+// General returns the general rate and tiers in effect at cycle: those of the latest change without
+// an address effective at cycle, or the schedule's default rate and tiers if there is none
+func (f *FeeSchedule) General(cycle int) (string, []FeeTier) {
+	if change := f.Change("", cycle); change != nil {
+		return change.Rate, change.Tiers
+	}
+	return f.Default, f.Tiers
+}
+
+// FeeTier is a fee rate charged to delegations with at least Min XTZ at the cycle's snapshot
+type FeeTier struct {
+	Min  float64 `json:"min"`
+	Rate string  `json:"rate"`
+}
+
+// Tier returns the rate of the tier with the highest minimum that a balance in mutez meets,
+// or an empty string if the balance meets no tier
+func Tier(tiers []FeeTier, balance int64) string {
+	rate := ""
+	var min int64 = -1
+	for _, tier := range tiers {
+		tierMin := int64(math.Round(tier.Min * goTezos.MUTEZ))
+		if balance >= tierMin && tierMin > min {
+			rate = tier.Rate
+			min = tierMin
+		}
+	}
+	return rate
+}
+
+// ReadFeeSchedule reads the fee schedule from its file and checks every address and rate in it
+func (f *FeeSchedule) ReadFeeSchedule() error {
+	jsonFile, err := os.Open(f.File)
+	if err != nil {
+		return err
+	}
+	defer jsonFile.Close()
+
+	byteValue, err := ioutil.ReadAll(jsonFile)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(byteValue, f)
+	if err != nil {
+		return err
+	}
+
+	if f.Default != "" {
+		if _, err = ParseRate(f.Default); err != nil {
+			return fmt.Errorf("default: %v", err)
+		}
+	}
+	for addr, rate := range f.Addresses {
+		if err = address.CheckJSON(byteValue, addr); err != nil {
+			return err
+		}
+		if _, err = ParseRate(rate); err != nil {
+			return fmt.Errorf("%s: %v", addr, err)
+		}
+	}
+	for _, change := range f.History {
+		if change.Cycle < 0 {
+			return fmt.Errorf("history cycle %d: cycle cannot be negative", change.Cycle)
+		}
+		if change.Address != "" {
+			if err = address.CheckJSON(byteValue, change.Address); err != nil {
+				return err
+			}
+			if len(change.Tiers) > 0 {
+				return fmt.Errorf("history cycle %d: a change for %s cannot have tiers", change.Cycle, change.Address)
+			}
+		}
+		if change.Rate == "" && len(change.Tiers) == 0 {
+			return fmt.Errorf("history cycle %d: no rate or tiers", change.Cycle)
+		}
+		if change.Rate != "" {
+			if _, err = ParseRate(change.Rate); err != nil {
+				return fmt.Errorf("history cycle %d: %v", change.Cycle, err)
+			}
+		}
+		if err = checkTiers(change.Tiers); err != nil {
+			return fmt.Errorf("history cycle %d: %v", change.Cycle, err)
+		}
+	}
+
+	return checkTiers(f.Tiers)
+}
+
+// checkTiers checks the minimum balance and rate of every tier
+func checkTiers(tiers []FeeTier) error {
+	for _, tier := range tiers {
+		if tier.Min < 0 {
+			return fmt.Errorf("tier %v: minimum balance cannot be negative", tier.Min)
+		}
+		if _, err := ParseRate(tier.Rate); err != nil {
+			return fmt.Errorf("tier %v: %v", tier.Min, err)
+		}
+	}
+	return nil
+}
+
+// Redirects is a configuration option to pay the rewards earned by a delegation to another address
+type Redirects struct {
+	File      string
+	Addresses map[string]string
+}
+
+// ReadRedirects reads a json object mapping delegations to the addresses their rewards should be paid to,
+// and checks every address in it
+func (r *Redirects) ReadRedirects() error {
+	jsonFile, err := os.Open(r.File)
+	if err != nil {
+		return err
+	}
+	defer jsonFile.Close()
+
+	byteValue, err := ioutil.ReadAll(jsonFile)
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(byteValue, &r.Addresses); err != nil {
+		return err
+	}
+
+	for delegation, destination := range r.Addresses {
+		if err = address.CheckJSON(byteValue, delegation); err != nil {
+			return err
+		}
+		if err = address.CheckJSON(byteValue, destination); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 //PaymentsOverride is a configuration option to override the payments calculation with your own
 type PaymentsOverride struct {
 	File     string
 	Payments []goTezos.Payment
+	Memos    map[string]string
 }
 
-// ReadPaymentsOverride generates the Payment Struct for the payer
-func (p *PaymentsOverride) ReadPaymentsOverride() ([]goTezos.Payment, error) {
-	jsonFile, err := os.Open(p.File)
+// ReadBlacklist reads a json string array of addresses that should not be paid out to, and checks them
+func ReadBlacklist(file string) ([]string, error) {
+	jsonFile, err := os.Open(file)
 	if err != nil {
-		return []goTezos.Payment{}, err
+		return []string{}, err
 	}
 	defer jsonFile.Close()
 
 	byteValue, err := ioutil.ReadAll(jsonFile)
 	if err != nil {
-		return []goTezos.Payment{}, err
+		return []string{}, err
 	}
 
-	var payments []goTezos.Payment
-	err = json.Unmarshal(byteValue, &payments)
+	var blacklist []string
+	err = json.Unmarshal(byteValue, &blacklist)
 	if err != nil {
-		return payments, err
+		return blacklist, err
+	}
+
+	// surrounding spaces were always ignored, so they are trimmed rather than refused
+	for i, entry := range blacklist {
+		blacklist[i] = strings.TrimSpace(entry)
+		if err = address.Check(blacklist[i]); err != nil {
+			return blacklist, fmt.Errorf("line %d: %v", address.Line(byteValue, entry), err)
+		}
 	}
 
-	return payments, nil
+	return blacklist, nil
 }
@@ -0,0 +1,202 @@
+package options
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	goTezos "github.com/DefinitelyNotAGoat/go-tezos"
+	"github.com/DefinitelyNotAGoat/payman/address"
+)
+
+const (
+	// UnitXTZ is the unit of override amounts in tez, with up to 6 decimals
+	UnitXTZ = "XTZ"
+	// UnitMutez is the unit of override amounts in whole mutez
+	UnitMutez = "mutez"
+)
+
+// xtzAmount matches an amount in XTZ: a whole number with up to 6 decimals
+var xtzAmount = regexp.MustCompile(`^\d+(\.\d{1,6})?$`)
+
+// overridePayment is a payment read from a payments override file, its amount in mutez
+type overridePayment struct {
+	Address string
+	Amount  int64
+	Memo    string
+	Where   string
+}
+
+// ReadPaymentsOverride reads the payments in the override file, as csv or json depending on its extension,
+// and its memos into Memos. Every address is checked, and addresses paid twice or negative amounts refused.
+//
+// A csv file has a row for each payment of address, amount with its unit (e.g. 1.5 XTZ or 1500000 mutez)
+// and an optional memo, and may start with a header row. A json file is an array of objects with an
+// address, an amount with its unit or a number of mutez, and an optional memo.
+func (p *PaymentsOverride) ReadPaymentsOverride() ([]goTezos.Payment, error) {
+	byteValue, err := ioutil.ReadFile(p.File)
+	if err != nil {
+		return []goTezos.Payment{}, err
+	}
+
+	var read []overridePayment
+	switch strings.ToLower(filepath.Ext(p.File)) {
+	case ".csv":
+		read, err = readOverrideCSV(byteValue)
+	case ".json":
+		read, err = readOverrideJSON(byteValue)
+	default:
+		return []goTezos.Payment{}, fmt.Errorf("unknown format, name the file .csv or .json")
+	}
+	if err != nil {
+		return []goTezos.Payment{}, err
+	}
+
+	payments := []goTezos.Payment{}
+	memos := make(map[string]string)
+	seen := make(map[string]string)
+	for _, payment := range read {
+		if err = address.Check(payment.Address); err != nil {
+			return []goTezos.Payment{}, fmt.Errorf("%s: %v", payment.Where, err)
+		}
+		if where, ok := seen[payment.Address]; ok {
+			return []goTezos.Payment{}, fmt.Errorf("%s: %s is already paid at %s", payment.Where, payment.Address, where)
+		}
+		if payment.Amount < 0 {
+			return []goTezos.Payment{}, fmt.Errorf("%s: amount paid to %s cannot be negative", payment.Where, payment.Address)
+		}
+
+		seen[payment.Address] = payment.Where
+		payments = append(payments, goTezos.Payment{Address: payment.Address, Amount: float64(payment.Amount)})
+		if payment.Memo != "" {
+			memos[payment.Address] = payment.Memo
+		}
+	}
+
+	p.Memos = memos
+	return payments, nil
+}
+
+// readOverrideCSV reads the payments of a csv override file, a payment on each line, skipping blank lines
+// and reading a line at a time so errors name the line of the file they are on
+func readOverrideCSV(data []byte) ([]overridePayment, error) {
+	var payments []overridePayment
+	header := true
+	for i, text := range strings.Split(string(data), "\n") {
+		line := i + 1
+		text = strings.TrimRight(text, "\r")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		reader := csv.NewReader(strings.NewReader(text))
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		record, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		if header {
+			header = false
+			if strings.EqualFold(strings.TrimSpace(record[0]), "address") {
+				continue
+			}
+		}
+		if len(record) < 2 || len(record) > 3 {
+			return nil, fmt.Errorf("line %d: expected address, amount and an optional memo, got %d fields", line, len(record))
+		}
+
+		amount, err := ParseAmount(record[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		payment := overridePayment{Address: strings.TrimSpace(record[0]), Amount: amount, Where: fmt.Sprintf("line %d", line)}
+		if len(record) == 3 {
+			payment.Memo = strings.TrimSpace(record[2])
+		}
+		payments = append(payments, payment)
+	}
+	return payments, nil
+}
+
+// readOverrideJSON reads the payments of a json override file, amounts given as numbers are in mutez
+// and rounded as they always were
+func readOverrideJSON(data []byte) ([]overridePayment, error) {
+	var entries []struct {
+		Address string          `json:"address"`
+		Amount  json.RawMessage `json:"amount"`
+		Memo    string          `json:"memo"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	var payments []overridePayment
+	for i, entry := range entries {
+		where := fmt.Sprintf("payment %d", i+1)
+		if line := address.Line(data, entry.Address); line > 0 {
+			where = fmt.Sprintf("line %d", line)
+		}
+
+		var amount int64
+		var unit string
+		if len(entry.Amount) == 0 {
+			return nil, fmt.Errorf("%s: no amount", where)
+		}
+		if err := json.Unmarshal(entry.Amount, &unit); err == nil {
+			if amount, err = ParseAmount(unit); err != nil {
+				return nil, fmt.Errorf("%s: %v", where, err)
+			}
+		} else {
+			var mutez float64
+			if err = json.Unmarshal(entry.Amount, &mutez); err != nil {
+				return nil, fmt.Errorf("%s: invalid amount %s", where, string(entry.Amount))
+			}
+			amount = int64(math.Round(mutez))
+		}
+
+		payments = append(payments, overridePayment{Address: entry.Address, Amount: amount, Memo: entry.Memo, Where: where})
+	}
+	return payments, nil
+}
+
+// ParseAmount parses an amount with its unit, XTZ with up to 6 decimals or mutez (e.g. 1.5 XTZ or
+// 1500000 mutez), and returns it in mutez
+func ParseAmount(amount string) (int64, error) {
+	fields := strings.Fields(amount)
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("invalid amount '%s', must have a unit (e.g. 1.5 XTZ or 1500000 mutez)", amount)
+	}
+
+	switch {
+	case strings.EqualFold(fields[1], UnitXTZ):
+		// big.Rat would also take fractions and exponents
+		if !xtzAmount.MatchString(fields[0]) {
+			return 0, fmt.Errorf("invalid amount '%s', XTZ must be a number with at most 6 decimals", amount)
+		}
+		r, ok := new(big.Rat).SetString(fields[0])
+		if !ok {
+			return 0, fmt.Errorf("invalid amount '%s'", amount)
+		}
+		r.Mul(r, big.NewRat(goTezos.MUTEZ, 1))
+		if !r.IsInt() || !r.Num().IsInt64() {
+			return 0, fmt.Errorf("invalid amount '%s', too large", amount)
+		}
+		return r.Num().Int64(), nil
+	case strings.EqualFold(fields[1], UnitMutez):
+		mutez, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid amount '%s', mutez must be a whole number", amount)
+		}
+		return mutez, nil
+	}
+	return 0, fmt.Errorf("invalid amount '%s', unit must be XTZ or mutez", amount)
+}
@@ -0,0 +1,76 @@
+package payer
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/DefinitelyNotAGoat/payman/ledger"
+)
+
+const (
+	// defaultBatchSize is the number of payments in each operation when the conf does not set one
+	defaultBatchSize = 100
+	// transferGas is the gas a transaction to an implicit account consumes
+	transferGas = 10207
+	// contractTransferGas is a generous estimate of the gas a transaction to a KT1 contract consumes
+	contractTransferGas = 30000
+	// revealGas is the gas a reveal consumes
+	revealGas = 10000
+	// transferSize is a generous estimate of the forged size of a transaction in bytes
+	transferSize = 100
+	// operationSize is the forged size of an operation's branch and signature in bytes
+	operationSize = 32 + 64
+)
+
+// splitIntoBatches splits payments into pending ledger batches, each batch recorded in the ledger maps
+// to a single forged operation. Batches hold at most the batch size of the conf, and are packed so the
+// estimated gas and size of each stays under the hard gas limit per operation and the maximum operation
+// data length of the network. Payments to KT1 contracts are batched apart from payments to implicit
+// accounts, so a contract whose script fails cannot reject a batch of plain transfers.
+func (payer *Payer) splitIntoBatches(payments []ledger.Payment) []ledger.Batch {
+	var implicit, contracts []ledger.Payment
+	for _, payment := range payments {
+		if strings.HasPrefix(payment.Address, "KT1") {
+			contracts = append(contracts, payment)
+		} else {
+			implicit = append(implicit, payment)
+		}
+	}
+
+	batches := payer.pack(implicit, payer.estimatedGas(transferGas))
+	return append(batches, payer.pack(contracts, payer.estimatedGas(contractTransferGas))...)
+}
+
+// pack splits payments into batches in order, starting a new batch whenever the next payment would
+// take the batch over the batch size or the network limits, given the gas each payment is estimated to use
+func (payer *Payer) pack(payments []ledger.Payment, gas int64) []ledger.Batch {
+	size := payer.conf.BatchSize
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	hardGas, _ := strconv.ParseInt(payer.gt.Constants.HardGasLimitPerOperation, 10, 64)
+	maxLength := int64(payer.gt.Constants.MaxOperationDataLength)
+
+	var batches []ledger.Batch
+	var batch []ledger.Payment
+	for _, payment := range payments {
+		n := int64(len(batch) + 1)
+		if len(batch) > 0 && (len(batch) == size || (hardGas > 0 && n*gas > hardGas) || (maxLength > 0 && operationSize+n*transferSize > maxLength)) {
+			batches = append(batches, ledger.Batch{Payments: batch, Status: ledger.StatusPending})
+			batch = nil
+		}
+		batch = append(batch, payment)
+	}
+	if len(batch) > 0 {
+		batches = append(batches, ledger.Batch{Payments: batch, Status: ledger.StatusPending})
+	}
+	return batches
+}
+
+// estimatedGas returns the gas limit a transaction consuming gas is expected to be given when it is forged
+func (payer *Payer) estimatedGas(gas int64) int64 {
+	if !payer.conf.Simulate {
+		return int64(payer.conf.NetworkGasLimit)
+	}
+	return withMargin(gas, payer.conf.SimulationMargin)
+}
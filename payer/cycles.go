@@ -0,0 +1,84 @@
+package payer
+
+import (
+	"fmt"
+
+	goTezos "github.com/DefinitelyNotAGoat/go-tezos"
+)
+
+// PayableCycle returns the latest cycle whose rewards can be paid out. A baker's rewards for a cycle
+// are unfrozen PreservedCycles cycles after it ends, the cycle offset lets bakers who pay from their
+// own funds pay out that many cycles earlier.
+func PayableCycle(gt *goTezos.GoTezos, offset int) (int, error) {
+	head, err := gt.Block.GetHead()
+	if err != nil {
+		return 0, fmt.Errorf("could not get payable cycle: %v", err)
+	}
+
+	if offset > gt.Constants.PreservedCycles {
+		offset = gt.Constants.PreservedCycles
+	}
+
+	return head.Metadata.Level.Cycle - 1 - gt.Constants.PreservedCycles + offset, nil
+}
+
+// PayoutCycles pays out the rewards of several cycles merged into a single payout, so each address
+// is paid once for all of them. A single cycle is paid out as Payout would.
+func (payer *Payer) PayoutCycles(cycles []int) (Report, [][]byte, error) {
+	if len(cycles) == 0 {
+		return Report{Delegate: payer.conf.Delegate}, nil, fmt.Errorf("could not pay out: no cycles")
+	}
+
+	payer.conf.Cycle = cycles[0]
+	if len(cycles) == 1 {
+		return payer.Payout()
+	}
+
+	rewards, err := payer.mergedReport(cycles)
+	if err != nil {
+		return rewards, nil, err
+	}
+
+	return payer.payReport(rewards)
+}
+
+// mergedReport returns a report of the rewards of every delegation summed over the cycles
+func (payer *Payer) mergedReport(cycles []int) (Report, error) {
+	merged := Report{Delegate: payer.conf.Delegate, Cycle: cycles[0], Cycles: cycles}
+	index := make(map[string]int)
+
+	for _, cycle := range cycles {
+		rewards, err := payer.getReport(cycle)
+		if err != nil {
+			return merged, err
+		}
+
+		merged.CycleRewards += rewards.CycleRewards
+		merged.StakingBalance = rewards.StakingBalance
+		merged.Withheld += rewards.Withheld
+		merged.SelfBaked += rewards.SelfBaked
+		merged.Remainder += rewards.Remainder
+
+		for _, payout := range rewards.Payouts {
+			i, ok := index[payout.Address]
+			if !ok {
+				index[payout.Address] = len(merged.Payouts)
+				merged.Payouts = append(merged.Payouts, payout)
+				continue
+			}
+
+			// balances and shares are those of the latest cycle, the rate is only kept if it never changed
+			m := &merged.Payouts[i]
+			m.Balance = payout.Balance
+			m.Share = payout.Share
+			if m.Rate != nil && payout.Rate != nil && m.Rate.Cmp(payout.Rate) != 0 {
+				m.Rate = nil
+			}
+			m.Gross += payout.Gross
+			m.Fee += payout.Fee
+			m.Net += payout.Net
+		}
+	}
+
+	return merged, nil
+}
@@ -0,0 +1,37 @@
+package payer
+
+import (
+	"math/big"
+
+	"github.com/DefinitelyNotAGoat/payman/options"
+)
+
+// feeRate resolves the fee rate charged at cycle to a delegation with balance mutez at the snapshot:
+// the latest change in the fee history for its address effective at cycle, or else its negotiated rate
+// in the fee schedule, or else the general schedule in effect at cycle, which is the latest change in
+// the fee history for everyone effective at cycle or else the fee schedule's default rate and tiers:
+// the rate of the highest balance tier it meets, or else its rate. If none applies the fee passed is
+// charged.
+func (payer *Payer) feeRate(address string, balance int64, cycle int) (*big.Rat, error) {
+	schedule := payer.conf.FeeSchedule
+	if change := schedule.Change(address, cycle); change != nil {
+		return options.ParseRate(change.Rate)
+	}
+
+	if rate, ok := schedule.Addresses[address]; ok {
+		return options.ParseRate(rate)
+	}
+
+	rate, tiers := schedule.General(cycle)
+	if tier := options.Tier(tiers, balance); tier != "" {
+		return options.ParseRate(tier)
+	}
+	if rate != "" {
+		return options.ParseRate(rate)
+	}
+	if schedule.Default != "" {
+		return options.ParseRate(schedule.Default)
+	}
+
+	return options.ParseRate(payer.conf.Fee)
+}
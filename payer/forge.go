@@ -0,0 +1,194 @@
+package payer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+
+	goTezos "github.com/DefinitelyNotAGoat/go-tezos"
+)
+
+// forge forges a transaction operation for each batch of payments with a fresh counter and branch,
+// has the signer sign each one, and preapplies them together so the node checks every batch as it
+// would apply them in order. It returns the operations and the signed operations ready to inject.
+func (payer *Payer) forge(batches [][]goTezos.Payment) (*Prepared, []string, error) {
+	if payer.signer == nil {
+		return nil, nil, fmt.Errorf("could not forge operations: no signer for the paying wallet")
+	}
+
+	publicKey, err := payer.signer.PublicKey()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not forge operations: %v", err)
+	}
+
+	prepared, err := payer.forgeUnsigned(payer.signer.Address(), publicKey, batches)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = prepared.Sign(payer.signer); err != nil {
+		return nil, nil, err
+	}
+
+	if err = payer.preapply(prepared, prepared.Operations); err != nil {
+		return nil, nil, err
+	}
+	signed, err := prepared.Signed()
+	return prepared, signed, err
+}
+
+// forgeUnsigned forges a transaction operation from source for each batch of payments, with a fresh
+// counter and branch and estimated fees and limits, and returns them unsigned. If the public key of
+// source was never revealed, a reveal of publicKey is prepended to the first batch.
+func (payer *Payer) forgeUnsigned(source, publicKey string, batches [][]goTezos.Payment) (*Prepared, error) {
+	head, err := payer.gt.Block.GetHead()
+	if err != nil {
+		return nil, fmt.Errorf("could not forge operations: %v", err)
+	}
+
+	counter, err := payer.counter(source)
+	if err != nil {
+		return nil, fmt.Errorf("could not forge operations: %v", err)
+	}
+
+	revealed, err := payer.revealed(source)
+	if err != nil {
+		return nil, fmt.Errorf("could not forge operations: %v", err)
+	}
+	if !revealed && publicKey == "" {
+		return nil, fmt.Errorf("could not forge operations: %s is not revealed, reveal it first with payman wallet reveal", source)
+	}
+
+	prepared := &Prepared{
+		Version:    preparedVersion,
+		Source:     source,
+		Branch:     head.Hash,
+		Protocol:   head.Protocol,
+		Level:      head.Header.Level,
+		Operations: make([]Operation, len(batches)),
+	}
+	for i, batch := range batches {
+		var contents []Content
+		if i == 0 && !revealed {
+			counter++
+			contents = append(contents, revealContents(source, publicKey, counter))
+		}
+		for _, payment := range batch {
+			if payment.Amount <= 0 {
+				continue
+			}
+			counter++
+			contents = append(contents, Content{StructContents: goTezos.StructContents{
+				Kind:        "transaction",
+				Source:      source,
+				Counter:     strconv.Itoa(counter),
+				Amount:      strconv.FormatInt(int64(math.Round(payment.Amount)), 10),
+				Destination: payment.Address,
+			}})
+		}
+
+		opBytes, err := payer.forgeEstimated(head, contents)
+		if err != nil {
+			return nil, err
+		}
+		prepared.Operations[i] = Operation{Contents: contents, Bytes: opBytes}
+	}
+
+	return prepared, nil
+}
+
+// Content is the contents of a manager operation, with the public key of a reveal, which go-tezos has no field for
+type Content struct {
+	goTezos.StructContents
+	PublicKey string `json:"public_key,omitempty"`
+}
+
+// source returns the source of the contents of an operation
+func source(contents []Content) string {
+	if len(contents) == 0 {
+		return ""
+	}
+	return contents[0].Source
+}
+
+// counter returns the counter of the address
+func (payer *Payer) counter(address string) (int, error) {
+	resp, err := payer.gt.Get("/chains/main/blocks/head/context/contracts/"+address+"/counter", nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not get counter of %s: %v", address, err)
+	}
+
+	var counter string
+	if err = json.Unmarshal(resp, &counter); err != nil {
+		return 0, fmt.Errorf("could not get counter of %s: %v", address, err)
+	}
+	return strconv.Atoi(counter)
+}
+
+// forgeContents has the node forge the contents of an operation on branch and returns its bytes in hex
+func (payer *Payer) forgeContents(branch string, contents []Content) (string, error) {
+	args, err := json.Marshal(struct {
+		Branch   string    `json:"branch"`
+		Contents []Content `json:"contents"`
+	}{branch, contents})
+	if err != nil {
+		return "", fmt.Errorf("could not forge operation: %v", err)
+	}
+
+	resp, err := payer.gt.Post("/chains/main/blocks/head/helpers/forge/operations", string(args))
+	if err != nil {
+		return "", nodeRefusal(fmt.Errorf("could not forge operation: %v", err), source(contents))
+	}
+
+	var opBytes string
+	if err = json.Unmarshal(resp, &opBytes); err != nil {
+		return "", fmt.Errorf("could not forge operation: %v", err)
+	}
+	return opBytes, nil
+}
+
+// preapply has the node apply signed operations of the prepared payout on top of the head without
+// injecting them, to catch any operation the network would refuse. It returns a transferErrors if
+// any transfer would fail, and a refusalError if the node refuses the operations outright for
+// reasons other than the paying wallet's own.
+func (payer *Payer) preapply(prepared *Prepared, operations []Operation) error {
+	type transfer struct {
+		Protocol  string    `json:"protocol"`
+		Branch    string    `json:"branch"`
+		Contents  []Content `json:"contents"`
+		Signature string    `json:"signature"`
+	}
+	transfers := make([]transfer, len(operations))
+	for i, op := range operations {
+		transfers[i] = transfer{Protocol: prepared.Protocol, Branch: prepared.Branch, Contents: op.Contents, Signature: op.Signature}
+	}
+
+	args, err := json.Marshal(transfers)
+	if err != nil {
+		return fmt.Errorf("could not preapply operations: %v", err)
+	}
+
+	resp, err := payer.gt.Post("/chains/main/blocks/head/helpers/preapply/operations", string(args))
+	if err != nil {
+		return nodeRefusal(fmt.Errorf("could not preapply operations: %v", err), prepared.Source)
+	}
+
+	// operations that would fail are still valid to include, so the node only reports them in their results
+	var results []simulation
+	if err = json.Unmarshal(resp, &results); err != nil {
+		return fmt.Errorf("could not preapply operations: %v", err)
+	}
+	var failed transferErrors
+	for i, result := range results {
+		for k, content := range result.Contents {
+			if status := content.Metadata.OperationResult.Status; status == "failed" && i < len(operations) && k < len(operations[i].Contents) {
+				failed = append(failed, newTransferError(operations[i].Contents[k], content.Metadata.OperationResult.Errors))
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
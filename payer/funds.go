@@ -0,0 +1,132 @@
+package payer
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DefinitelyNotAGoat/payman/options"
+)
+
+// checkFunds checks the paying wallet can afford every payout in the report: the amounts paid, the
+// estimated fee of each transaction and of revealing the wallet if it was never revealed, and the burn
+// of allocating each empty account paid. If it cannot,
+// checkFunds refuses to start the payout, or with a partial policy defers every payout the wallet
+// cannot afford, paying the cheapest payouts first so as many addresses as possible are paid.
+func (payer *Payer) checkFunds(source string, rewards *Report) error {
+	var indexes []int
+	var destinations []string
+	for i, payout := range rewards.Payouts {
+		if payout.Paid() > 0 {
+			indexes = append(indexes, i)
+			destinations = append(destinations, destination(payout))
+		}
+	}
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	balances, err := payer.getBalancesAtBlock(append([]string{source}, destinations...), "head")
+	if err != nil {
+		return fmt.Errorf("could not check funds of %s: %v", source, err)
+	}
+	balance := balances[0]
+
+	burn, err := payer.allocationBurn()
+	if err != nil {
+		return fmt.Errorf("could not check funds of %s: %v", source, err)
+	}
+
+	revealed, err := payer.revealed(source)
+	if err != nil {
+		return fmt.Errorf("could not check funds of %s: %v", source, err)
+	}
+	var reveal int64
+	if !revealed {
+		reveal = payer.estimatedFee(revealGas)
+	}
+	total, fees, burnt := reveal, reveal, int64(0)
+
+	// the first payout to an empty implicit account pays for allocating it
+	costs := make([]int64, len(indexes))
+	empty := make(map[string]bool)
+	for k, i := range indexes {
+		fee := payer.estimatedFee(transferGas)
+		if strings.HasPrefix(destinations[k], "KT1") {
+			fee = payer.estimatedFee(contractTransferGas)
+		}
+		costs[k] = rewards.Payouts[i].Paid() + fee
+		fees += fee
+		total += costs[k]
+		if balances[k+1] == 0 && !strings.HasPrefix(destinations[k], "KT1") && !empty[destinations[k]] {
+			empty[destinations[k]] = true
+			total += burn
+			burnt += burn
+		}
+	}
+	if total <= balance {
+		return nil
+	}
+
+	if payer.conf.Underfunded != options.UnderfundedPartial {
+		return fmt.Errorf("wallet %s has %d mutez but the payout needs %d: %d paid, %d in network fees and %d burnt allocating %d empty accounts",
+			source, balance, total, total-fees-burnt, fees, burnt, len(empty))
+	}
+
+	order := make([]int, len(indexes))
+	for k := range order {
+		order[k] = k
+	}
+	sort.SliceStable(order, func(a, b int) bool { return costs[order[a]] < costs[order[b]] })
+
+	// payouts to the same destination may be paid or deferred apart, so the burn goes to the first one paid
+	spent := reveal
+	allocated := make(map[string]bool)
+	var paid int
+	for _, k := range order {
+		cost := costs[k]
+		if empty[destinations[k]] && !allocated[destinations[k]] {
+			cost += burn
+		}
+		if spent+cost <= balance {
+			spent += cost
+			allocated[destinations[k]] = true
+			paid++
+			continue
+		}
+		rewards.Payouts[indexes[k]].Deferred = true
+	}
+	if paid == 0 {
+		return fmt.Errorf("wallet %s has %d mutez, not enough to pay any payout", source, balance)
+	}
+
+	return nil
+}
+
+// estimatedFee returns the most a transaction or reveal consuming gas is expected to pay in fees: with
+// simulation the minimal fees for its gas plus margin, and without it the network fee. Each is charged
+// the fixed fee and size of an operation of its own, so the estimate errs high.
+func (payer *Payer) estimatedFee(gas int64) int64 {
+	if !payer.conf.Simulate {
+		return int64(payer.conf.NetworkFee)
+	}
+	return minimalFees + ceilDiv(payer.estimatedGas(gas)*minimalNanotezPerGasUnit, 1000) + ceilDiv((operationSize+transferSize)*minimalNanotezPerByte, 1000)
+}
+
+// allocationBurn returns the mutez burnt to allocate an empty implicit account
+func (payer *Payer) allocationBurn() (int64, error) {
+	costPerByte, err := strconv.ParseInt(payer.gt.Constants.CostPerByte, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cost per byte '%s': %v", payer.gt.Constants.CostPerByte, err)
+	}
+	return int64(payer.gt.Constants.OriginationSize) * costPerByte, nil
+}
+
+// destination returns the address a payout is paid to
+func destination(payout Payout) string {
+	if payout.Destination != "" {
+		return payout.Destination
+	}
+	return payout.Address
+}
@@ -0,0 +1,72 @@
+package payer
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// checkLimits refuses to pay out a report that breaks the spending limits in the conf, unless they are
+// overridden, so a wrong balance from the node or a typo in a payments override cannot empty the
+// paying wallet. Payouts are checked against the maximum total and maximum payment to a single
+// address, against the rewards of the cycles paid, and against the total paid per cycle by the
+// previous payout in the ledger.
+func (payer *Payer) checkLimits(rewards Report) error {
+	if payer.conf.OverrideLimits {
+		return nil
+	}
+
+	var total int64
+	var addresses []string
+	paid := make(map[string]int64)
+	for _, payment := range rewards.Payments() {
+		amount := int64(math.Round(payment.Amount))
+		total += amount
+		if _, ok := paid[payment.Address]; !ok {
+			addresses = append(addresses, payment.Address)
+		}
+		paid[payment.Address] += amount
+	}
+
+	var violations []string
+	if payer.conf.MaxTotal > 0 && total > payer.conf.MaxTotal {
+		violations = append(violations, fmt.Sprintf("pays %d mutez in total, over the maximum of %d", total, payer.conf.MaxTotal))
+	}
+
+	if payer.conf.MaxPayment > 0 {
+		for _, address := range addresses {
+			if paid[address] > payer.conf.MaxPayment {
+				violations = append(violations, fmt.Sprintf("pays %d mutez to %s, over the maximum of %d", paid[address], address, payer.conf.MaxPayment))
+			}
+		}
+	}
+
+	// rewards carried over were earned in earlier cycles, everything else must come out of these cycles' rewards
+	if len(payer.conf.PaymentsOverride.Payments) == 0 && total-rewards.TotalCarried() > rewards.CycleRewards {
+		violations = append(violations, fmt.Sprintf("pays %d mutez of rewards, more than the %d mutez earned", total-rewards.TotalCarried(), rewards.CycleRewards))
+	}
+
+	if payer.conf.MaxDeviation > 0 && payer.ledger != nil {
+		if previous := payer.ledger.Previous(rewards.Delegate, rewards.Cycle); previous != nil {
+			last := float64(previous.Total()) / float64(cycleCount(previous.Merged))
+			current := float64(total) / float64(cycleCount(rewards.Cycles))
+			if last > 0 && math.Abs(current-last)/last > payer.conf.MaxDeviation {
+				violations = append(violations, fmt.Sprintf("pays %.0f mutez per cycle, %.0f%% off the %.0f paid per cycle at cycle %d, over the maximum deviation of %.0f%%",
+					current, math.Abs(current-last)/last*100, last, previous.Cycle, payer.conf.MaxDeviation*100))
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("payout breaks spending limits, pass --override-limits to pay it anyway: %s", strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+// cycleCount returns the number of cycles paid by a payout that merged the cycles, or a single cycle if none
+func cycleCount(merged []int) int {
+	if len(merged) == 0 {
+		return 1
+	}
+	return len(merged)
+}
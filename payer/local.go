@@ -0,0 +1,162 @@
+package payer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/DefinitelyNotAGoat/payman/address"
+	"github.com/Messer4/base58check"
+)
+
+var (
+	// tags of the public key hashes and public keys of each curve, by the prefix of their encoding
+	hashTags = map[string]byte{"tz1": 0, "tz2": 1, "tz3": 2}
+	keyTags  = map[string]byte{"edpk": 0, "sppk": 1, "p2pk": 2}
+	// prefixes of the base58check encodings of the public keys of each curve, and the sizes of the keys
+	keyPrefixes = map[string][]byte{"edpk": {13, 15, 37, 217}, "sppk": {3, 254, 226, 86}, "p2pk": {3, 178, 139, 127}}
+	keySizes    = map[string]int{"edpk": 32, "sppk": 33, "p2pk": 33}
+)
+
+const (
+	// tags of reveals and transactions from babylon on, and before it
+	revealTag            = 107
+	transactionTag       = 108
+	legacyRevealTag      = 7
+	legacyTransactionTag = 8
+)
+
+// forgeLocally forges the contents of an operation on branch as the node would, without a node, so the
+// bytes of a prepared payout can be checked against its contents where the key is kept offline. Legacy
+// forges them as protocols before babylon did.
+func forgeLocally(branch string, contents []Content, legacy bool) (string, error) {
+	var buf bytes.Buffer
+
+	data, err := base58check.Decode(branch)
+	if err != nil || len(data) != len(blockPrefix)+32 || !bytes.HasPrefix(data, blockPrefix) {
+		return "", fmt.Errorf("invalid branch %s", branch)
+	}
+	buf.Write(data[len(blockPrefix):])
+
+	for _, content := range contents {
+		switch {
+		case content.Kind == "reveal" && legacy:
+			buf.WriteByte(legacyRevealTag)
+		case content.Kind == "reveal":
+			buf.WriteByte(revealTag)
+		case content.Kind == "transaction" && legacy:
+			buf.WriteByte(legacyTransactionTag)
+		case content.Kind == "transaction":
+			buf.WriteByte(transactionTag)
+		default:
+			return "", fmt.Errorf("cannot forge a %s", content.Kind)
+		}
+
+		// the source of a manager operation is a contract before babylon, and a public key hash from it on
+		var source []byte
+		if legacy {
+			source, err = forgeContract(content.Source)
+		} else {
+			source, err = forgePublicKeyHash(content.Source)
+		}
+		if err != nil {
+			return "", err
+		}
+		buf.Write(source)
+
+		for _, n := range []string{content.Fee, content.Counter, content.GasLimit, content.StorageLimit} {
+			if err = forgeNat(&buf, n); err != nil {
+				return "", err
+			}
+		}
+
+		if content.Kind == "reveal" {
+			key, err := forgePublicKey(content.PublicKey)
+			if err != nil {
+				return "", err
+			}
+			buf.Write(key)
+			continue
+		}
+
+		if err = forgeNat(&buf, content.Amount); err != nil {
+			return "", err
+		}
+		destination, err := forgeContract(content.Destination)
+		if err != nil {
+			return "", err
+		}
+		buf.Write(destination)
+		// no parameters
+		buf.WriteByte(0)
+	}
+
+	return hex.EncodeToString(buf.Bytes()), nil
+}
+
+// forgeNat writes the natural number n, in decimal, as a zarith number: seven bits a byte, lowest first,
+// with the high bit set on every byte but the last
+func forgeNat(buf *bytes.Buffer, n string) error {
+	value, err := strconv.ParseUint(n, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid number '%s'", n)
+	}
+	for value >= 0x80 {
+		buf.WriteByte(byte(value&0x7f) | 0x80)
+		value >>= 7
+	}
+	buf.WriteByte(byte(value))
+	return nil
+}
+
+// forgePublicKeyHash returns the tag of the curve of a tz1, tz2 or tz3 address followed by its hash
+func forgePublicKeyHash(pkh string) ([]byte, error) {
+	hash, err := address.Decode(pkh)
+	if err != nil {
+		return nil, err
+	}
+	tag, ok := hashTags[pkh[:3]]
+	if !ok {
+		return nil, fmt.Errorf("invalid public key hash %s", pkh)
+	}
+	return append([]byte{tag}, hash...), nil
+}
+
+// forgeContract returns 0 followed by the public key hash of a tz address, or 1 followed by the hash
+// of a KT1 address and a padding byte
+func forgeContract(contract string) ([]byte, error) {
+	if !strings.HasPrefix(contract, "KT1") {
+		pkh, err := forgePublicKeyHash(contract)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{0}, pkh...), nil
+	}
+
+	hash, err := address.Decode(contract)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{1}, hash...), 0), nil
+}
+
+// forgePublicKey returns the tag of the curve of a public key followed by the key
+func forgePublicKey(publicKey string) ([]byte, error) {
+	if len(publicKey) < 4 {
+		return nil, fmt.Errorf("invalid public key %s", publicKey)
+	}
+	curve := publicKey[:4]
+	tag, ok := keyTags[curve]
+	if !ok {
+		return nil, fmt.Errorf("invalid public key %s", publicKey)
+	}
+
+	prefix := keyPrefixes[curve]
+	data, err := base58check.Decode(publicKey)
+	if err != nil || !bytes.HasPrefix(data, prefix) || len(data) != len(prefix)+keySizes[curve] {
+		return nil, fmt.Errorf("invalid public key %s", publicKey)
+	}
+	return append([]byte{tag}, data[len(prefix):]...), nil
+}
@@ -0,0 +1,93 @@
+package payer
+
+import (
+	"testing"
+
+	goTezos "github.com/DefinitelyNotAGoat/go-tezos"
+)
+
+// the transaction forged by the node in the Tezos RPC documentation and a reveal from the ConseilJS
+// codec tests, with their forged bytes before babylon and from it on
+var (
+	docsBranch      = "BMHBtAaUv59LipV1czwZ5iQkxEktPJDE7A9sYXPkPeRzbBasNY8"
+	docsTransaction = Content{StructContents: goTezos.StructContents{
+		Kind:         "transaction",
+		Source:       "tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx",
+		Fee:          "50000",
+		Counter:      "3",
+		GasLimit:     "200",
+		StorageLimit: "0",
+		Amount:       "100000000",
+		Destination:  "tz1gjaF81ZRRvdzjobyfVNsAeSC6PScjfQwN",
+	}}
+	docsReveal = Content{StructContents: goTezos.StructContents{
+		Kind:         "reveal",
+		Source:       "tz1VJAdH2HRUZWfohXW59NPYQKFMe1csroaX",
+		Fee:          "0",
+		Counter:      "425748",
+		GasLimit:     "10000",
+		StorageLimit: "0",
+	}, PublicKey: "edpkuDuXgPVJi3YK2GKL6avAK3GyjqyvpJjG9gTY5r2y72R7Teo65i"}
+
+	docsBranchBytes            = "ce69c5713dac3537254e7be59759cf59c15abd530d10501ccf9028a5786314cf"
+	docsTransactionBytes       = "6c0002298c03ed7d454a101eb7022bc95f7e5f41ac78d0860303c8010080c2d72f0000e7670f32038107a59a2b9cfefae36ea21f5aa63c00"
+	docsLegacyTransactionBytes = "08000002298c03ed7d454a101eb7022bc95f7e5f41ac78d0860303c8010080c2d72f0000e7670f32038107a59a2b9cfefae36ea21f5aa63c00"
+	docsRevealBytes            = "6b0069ef8fb5d47d8a4321c94576a2316a632be8ce890094fe19904e00004c7b0501f6ea08f472b7e88791d3b8da49d64ac1e2c90f93c27e6531473305c6"
+	docsLegacyRevealBytes      = "07000069ef8fb5d47d8a4321c94576a2316a632be8ce890094fe19904e00004c7b0501f6ea08f472b7e88791d3b8da49d64ac1e2c90f93c27e6531473305c6"
+)
+
+func TestForgeLocally(t *testing.T) {
+	cases := []struct {
+		name     string
+		contents []Content
+		legacy   bool
+		want     string
+	}{
+		{name: "transaction", contents: []Content{docsTransaction}, want: docsTransactionBytes},
+		{name: "legacy transaction", contents: []Content{docsTransaction}, legacy: true, want: docsLegacyTransactionBytes},
+		{name: "reveal", contents: []Content{docsReveal}, want: docsRevealBytes},
+		{name: "legacy reveal", contents: []Content{docsReveal}, legacy: true, want: docsLegacyRevealBytes},
+		{name: "reveal and transaction", contents: []Content{docsReveal, docsTransaction}, want: docsRevealBytes + docsTransactionBytes},
+		{name: "legacy reveal and transaction", contents: []Content{docsReveal, docsTransaction}, legacy: true, want: docsLegacyRevealBytes + docsLegacyTransactionBytes},
+	}
+
+	for _, c := range cases {
+		got, err := forgeLocally(docsBranch, c.contents, c.legacy)
+		if err != nil {
+			t.Errorf("%s: forgeLocally() error: %v", c.name, err)
+			continue
+		}
+		if got != docsBranchBytes+c.want {
+			t.Errorf("%s: forgeLocally() = %s, want %s", c.name, got, docsBranchBytes+c.want)
+		}
+	}
+}
+
+func TestForgeLocallyInvalid(t *testing.T) {
+	badDestination := docsTransaction
+	badDestination.Destination = "tz2gjaF81ZRRvdzjobyfVNsAeSC6PScjfQwN"
+	badSource := docsTransaction
+	badSource.Source = "KT1PWx2mnDueood7fEmfbBDKx1D9BAnnXitn"
+	badKey := docsReveal
+	badKey.PublicKey = "sppkuDuXgPVJi3YK2GKL6avAK3GyjqyvpJjG9gTY5r2y72R7Teo65i"
+	badAmount := docsTransaction
+	badAmount.Amount = "-1"
+
+	cases := []struct {
+		name     string
+		branch   string
+		contents []Content
+	}{
+		{name: "branch", branch: "BMHBtAaUv59LipV1czwZ5iQkxEktPJDE7A9sYXPkPeRzbBasNY9", contents: []Content{docsTransaction}},
+		{name: "destination", branch: docsBranch, contents: []Content{badDestination}},
+		{name: "source", branch: docsBranch, contents: []Content{badSource}},
+		{name: "public key", branch: docsBranch, contents: []Content{badKey}},
+		{name: "amount", branch: docsBranch, contents: []Content{badAmount}},
+	}
+
+	for _, c := range cases {
+		if got, err := forgeLocally(c.branch, c.contents, false); err == nil {
+			t.Errorf("invalid %s: forgeLocally() = %s, want an error", c.name, got)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package payer
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+
+	goTezos "github.com/DefinitelyNotAGoat/go-tezos"
+	"github.com/DefinitelyNotAGoat/payman/atomicfile"
+	"github.com/DefinitelyNotAGoat/payman/ledger"
+	"github.com/DefinitelyNotAGoat/payman/signer"
+)
+
+// preparedVersion is the version of the prepared payout file format
+const preparedVersion = 1
+
+// maxOperationsTTL is the number of blocks after its branch that an operation can still be included
+const maxOperationsTTL = 60
+
+// Prepared is a payout forged for a wallet whose key is kept offline. It is written by payman payout
+// prepare, signed by payman sign, and preapplied and injected by payman payout broadcast. Each operation
+// is a batch of the ledger entry the payout is recorded under once it is broadcast.
+type Prepared struct {
+	Version    int
+	Source     string
+	Branch     string
+	Protocol   string
+	Level      int
+	Entry      ledger.Entry
+	Operations []Operation
+}
+
+// Operation is a forged operation of a prepared payout, its contents and its signature once signed
+type Operation struct {
+	Contents  []Content
+	Bytes     string
+	Signature string `json:",omitempty"`
+}
+
+// Prepare builds the payout of the cycles as PayoutCycles would, and forges its operations from
+// source without signing them, so they can be signed by a wallet kept offline. The public key of
+// source is only needed if it was never revealed, and may be empty otherwise.
+func (payer *Payer) Prepare(cycles []int, source, publicKey string) (Report, *Prepared, error) {
+	if len(cycles) == 0 {
+		return Report{Delegate: payer.conf.Delegate}, nil, fmt.Errorf("could not prepare payout: no cycles")
+	}
+
+	payer.conf.Cycle = cycles[0]
+	var rewards Report
+	var err error
+	if len(cycles) == 1 {
+		rewards, err = payer.report()
+	} else {
+		rewards, err = payer.mergedReport(cycles)
+	}
+	if err != nil {
+		return rewards, nil, err
+	}
+
+	payer.filter(&rewards)
+	payer.redirect(&rewards)
+
+	if err = payer.checkLimits(rewards); err != nil {
+		return rewards, nil, err
+	}
+	if err = payer.checkFunds(source, &rewards); err != nil {
+		return rewards, nil, err
+	}
+
+	entry := payer.newEntry(rewards)
+	if err = payer.checkPaid(entry); err != nil {
+		return rewards, nil, err
+	}
+	if len(entry.Batches) == 0 {
+		return rewards, nil, fmt.Errorf("could not prepare payout: nothing to pay")
+	}
+
+	batches := make([][]goTezos.Payment, len(entry.Batches))
+	for i, batch := range entry.Batches {
+		for _, payment := range batch.Payments {
+			batches[i] = append(batches[i], payment.Payment())
+		}
+	}
+
+	prepared, err := payer.forgeUnsigned(source, publicKey, batches)
+	if err != nil {
+		return rewards, nil, err
+	}
+	prepared.Entry = *entry
+
+	// payman sign checks the bytes without a node, so check now that it will forge them as the node did
+	if err = prepared.check(); err != nil {
+		return rewards, nil, fmt.Errorf("could not prepare payout: %v", err)
+	}
+
+	return rewards, prepared, nil
+}
+
+// Broadcast checks a signed prepared payout still forges to the bytes that were signed, preapplies
+// it and injects it, recording it in the ledger as Payout would. Broadcasting a payout that failed
+// part way through injects only the operations that did not reach the network.
+func (payer *Payer) Broadcast(prepared *Prepared) ([][]byte, error) {
+	signed, err := prepared.Signed()
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := payer.gt.Block.GetHead()
+	if err != nil {
+		return nil, fmt.Errorf("could not broadcast payout: %v", err)
+	}
+	if head.Header.Level-prepared.Level >= maxOperationsTTL {
+		return nil, fmt.Errorf("could not broadcast payout: prepared at level %d, operations expire after %d blocks, prepare and sign it again", prepared.Level, maxOperationsTTL)
+	}
+
+	// the bytes signed must be the contents reviewed, or the summary could hide what is really paid
+	for i, op := range prepared.Operations {
+		opBytes, err := payer.forgeContents(prepared.Branch, op.Contents)
+		if err != nil {
+			return nil, err
+		}
+		if opBytes != op.Bytes {
+			return nil, fmt.Errorf("could not broadcast payout: operation %d does not match its contents", i+1)
+		}
+	}
+
+	entry, err := payer.broadcastEntry(prepared, signed)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Operation
+	for i, batch := range entry.Batches {
+		if batch.Status == ledger.StatusForged {
+			pending = append(pending, prepared.Operations[i])
+		}
+	}
+	if len(pending) > 0 {
+		if err = payer.preapply(prepared, pending); err != nil {
+			return nil, err
+		}
+	}
+	if err = payer.record(entry, nil); err != nil {
+		return nil, err
+	}
+
+	responses, err := payer.injectForged(entry)
+	if err != nil || payer.conf.Confirmations == 0 {
+		return responses, err
+	}
+
+	return payer.confirm(entry)
+}
+
+// broadcastEntry returns the ledger entry to record the prepared payout under: the entry of an earlier
+// broadcast of the same operations, or a new entry if the ledger does not show its cycles as paid
+func (payer *Payer) broadcastEntry(prepared *Prepared, signed []string) (*ledger.Entry, error) {
+	if payer.ledger != nil {
+		previous := payer.ledger.Get(prepared.Entry.Delegate, prepared.Entry.Cycle)
+		if previous != nil && sameOperations(previous, signed) {
+			for i, batch := range previous.Batches {
+				if !batch.Injected() {
+					previous.Batches[i].Status = ledger.StatusForged
+					previous.Batches[i].Error = ""
+				}
+			}
+			return previous, nil
+		}
+	}
+
+	entry := prepared.Entry
+	entry.Batches = append([]ledger.Batch{}, prepared.Entry.Batches...)
+	if err := payer.checkPaid(&entry); err != nil {
+		return nil, err
+	}
+
+	for i := range entry.Batches {
+		entry.Batches[i].Operation = signed[i]
+		entry.Batches[i].RevealFee = prepared.Operations[i].RevealFee()
+		entry.Batches[i].Status = ledger.StatusForged
+	}
+	return &entry, nil
+}
+
+// sameOperations returns true if the entry's batches are the signed operations
+func sameOperations(entry *ledger.Entry, signed []string) bool {
+	if len(entry.Batches) != len(signed) {
+		return false
+	}
+	for i, batch := range entry.Batches {
+		if batch.Operation != signed[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadPrepared reads a prepared payout from file and checks its operations match the payments of its entry
+func ReadPrepared(file string) (*Prepared, error) {
+	byteValue, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read prepared payout %s: %v", file, err)
+	}
+
+	var p Prepared
+	if err = json.Unmarshal(byteValue, &p); err != nil {
+		return nil, fmt.Errorf("could not unmarshal prepared payout %s: %v", file, err)
+	}
+	if p.Version != preparedVersion {
+		return nil, fmt.Errorf("could not read prepared payout %s: unsupported version %d", file, p.Version)
+	}
+	if err = p.check(); err != nil {
+		return nil, fmt.Errorf("could not read prepared payout %s: %v", file, err)
+	}
+
+	return &p, nil
+}
+
+// check checks every operation is a batch of transactions from the source paying the payments of its
+// batch in the entry, the first one possibly revealing the source first, and that its bytes are its
+// contents forged, so what is signed is what is shown
+func (p *Prepared) check() error {
+	if len(p.Operations) != len(p.Entry.Batches) {
+		return fmt.Errorf("%d operations for %d batches", len(p.Operations), len(p.Entry.Batches))
+	}
+
+	for i, op := range p.Operations {
+		contents := op.Contents
+		if i == 0 && len(contents) > 0 && contents[0].Kind == "reveal" {
+			if contents[0].Source != p.Source {
+				return fmt.Errorf("operation %d reveals %s, not %s", i+1, contents[0].Source, p.Source)
+			}
+			if _, err := strconv.ParseInt(contents[0].Fee, 10, 64); err != nil {
+				return fmt.Errorf("operation %d has an invalid fee %s", i+1, contents[0].Fee)
+			}
+			contents = contents[1:]
+		}
+
+		payments := p.Entry.Batches[i].Payments
+		if len(contents) != len(payments) {
+			return fmt.Errorf("operation %d has %d transactions for %d payments", i+1, len(contents), len(payments))
+		}
+
+		for k, content := range contents {
+			if content.Kind != "transaction" || content.Source != p.Source {
+				return fmt.Errorf("operation %d is not a transaction from %s", i+1, p.Source)
+			}
+			if _, err := strconv.ParseInt(content.Fee, 10, 64); err != nil {
+				return fmt.Errorf("operation %d has an invalid fee %s", i+1, content.Fee)
+			}
+			amount, err := strconv.ParseInt(content.Amount, 10, 64)
+			if err != nil || content.Destination != payments[k].Address || amount != int64(math.Round(payments[k].Amount)) {
+				return fmt.Errorf("operation %d does not pay %s as its batch does", i+1, payments[k].Address)
+			}
+		}
+
+		if err := op.checkBytes(p.Branch); err != nil {
+			return fmt.Errorf("operation %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// checkBytes checks the bytes of the operation are its contents forged on branch, as protocols from
+// babylon on or earlier ones forge them
+func (op Operation) checkBytes(branch string) error {
+	for _, legacy := range []bool{false, true} {
+		opBytes, err := forgeLocally(branch, op.Contents, legacy)
+		if err != nil {
+			return err
+		}
+		if strings.EqualFold(opBytes, op.Bytes) {
+			return nil
+		}
+	}
+	return fmt.Errorf("bytes do not match its contents")
+}
+
+// Write writes the prepared payout to file, through a temporary file so a crash cannot leave a half
+// written payout behind
+func (p *Prepared) Write(file string) error {
+	byteValue, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal prepared payout: %v", err)
+	}
+
+	if err = atomicfile.Write(file, byteValue); err != nil {
+		return fmt.Errorf("could not write prepared payout %s: %v", file, err)
+	}
+	return nil
+}
+
+// Sign signs every operation of the prepared payout with the signer of its source
+func (p *Prepared) Sign(s signer.Signer) error {
+	if s.Address() != p.Source {
+		return fmt.Errorf("could not sign payout: operations are from %s, not %s", p.Source, s.Address())
+	}
+
+	for i, op := range p.Operations {
+		signature, err := s.Sign(op.Bytes)
+		if err != nil {
+			return err
+		}
+		p.Operations[i].Signature = signature
+	}
+	return nil
+}
+
+// Signed returns every operation of the prepared payout with its signature appended, ready to inject
+func (p *Prepared) Signed() ([]string, error) {
+	ops := make([]string, len(p.Operations))
+	for i, op := range p.Operations {
+		if op.Signature == "" {
+			return nil, fmt.Errorf("operation %d of the payout is not signed", i+1)
+		}
+
+		sigBytes, err := signer.Bytes(op.Signature)
+		if err != nil {
+			return nil, err
+		}
+		ops[i] = op.Bytes + hex.EncodeToString(sigBytes)
+	}
+	return ops, nil
+}
+
+// RevealFee returns the fee of the reveal of the source that the operation starts with, or 0 if it has none
+func (op Operation) RevealFee() int64 {
+	if len(op.Contents) == 0 || op.Contents[0].Kind != "reveal" {
+		return 0
+	}
+	fee, _ := strconv.ParseInt(op.Contents[0].Fee, 10, 64)
+	return fee
+}
+
+// TotalAmount returns the sum of the amounts transferred by the prepared payout in mutez
+func (p *Prepared) TotalAmount() int64 {
+	var total int64
+	for _, op := range p.Operations {
+		for _, content := range op.Contents {
+			amount, _ := strconv.ParseInt(content.Amount, 10, 64)
+			total += amount
+		}
+	}
+	return total
+}
+
+// TotalFees returns the sum of the network fees of the prepared payout in mutez
+func (p *Prepared) TotalFees() int64 {
+	var total int64
+	for _, op := range p.Operations {
+		for _, content := range op.Contents {
+			fee, _ := strconv.ParseInt(content.Fee, 10, 64)
+			total += fee
+		}
+	}
+	return total
+}
@@ -1,17 +1,25 @@
 package payer
 
 import (
+	"fmt"
+	"math"
+	"math/big"
 	"strconv"
 	"strings"
+	"time"
 
 	goTezos "github.com/DefinitelyNotAGoat/go-tezos"
+	"github.com/DefinitelyNotAGoat/payman/ledger"
 	"github.com/DefinitelyNotAGoat/payman/options"
+	"github.com/DefinitelyNotAGoat/payman/signer"
+	"github.com/DefinitelyNotAGoat/payman/tracker"
 )
 
 // Payer is a structure to represent pay operations
 type Payer struct {
 	gt     *goTezos.GoTezos
-	wallet goTezos.Wallet
+	signer signer.Signer
+	ledger *ledger.Ledger
 	conf   *options.Options
 }
 
@@ -21,14 +29,29 @@ type PayoutResults struct {
 	Payouts  []Payout
 }
 
-// Payout describes a single payout to a single address
+// Payout describes a single payout to a single address, amounts are in mutez
 type Payout struct {
-	Address  string
-	Share    float64
-	Gross    float64
-	Fee      float64
-	Total    float64
-	USDValue float64
+	Address     string
+	Destination string
+	Balance     int64
+	Share       float64
+	Rate        *big.Rat
+	Gross       int64
+	Fee         int64
+	Net         int64
+	Carried     int64
+	Deferred    bool
+	Skipped     string
+}
+
+// Paid returns the amount paid to the payout's address: its net rewards plus the rewards carried
+// over from earlier cycles, or nothing if the payout is deferred to a later cycle or was skipped
+// because the node refused it
+func (p *Payout) Paid() int64 {
+	if p.Deferred || p.Skipped != "" {
+		return 0
+	}
+	return p.Net + p.Carried
 }
 
 // Node describes the node's total in PayoutResults
@@ -40,55 +63,384 @@ type Node struct {
 	TotalSelfBakedUSD float64
 }
 
-// NewPayer returns is a contructor for Payer
-func NewPayer(gt *goTezos.GoTezos, wallet goTezos.Wallet, conf *options.Options) Payer {
-	return Payer{gt: gt, wallet: wallet, conf: conf}
+// NewPayer returns is a contructor for Payer. The signer signs payouts for the paying wallet, and may
+// be nil for dry runs. The ledger may be nil, in which case payouts are not recorded and duplicate
+// payouts are not detected.
+func NewPayer(gt *goTezos.GoTezos, signer signer.Signer, ledger *ledger.Ledger, conf *options.Options) Payer {
+	return Payer{gt: gt, signer: signer, ledger: ledger, conf: conf}
 }
 
 // Payout uses the payers configuration that calls it, to pay out for the cycle in the conf
-func (payer *Payer) Payout() (goTezos.DelegateReport, [][]byte, error) {
-	var payments []goTezos.Payment
-	rewards := &goTezos.DelegateReport{}
-
-	if len(payer.conf.PaymentsOverride.Payments) > 0 {
-		payments = payer.conf.PaymentsOverride.Payments
-	} else {
-		var err error
-		rewards, err = payer.gt.Delegate.GetReport(payer.conf.Delegate, payer.conf.Cycle, float64(payer.conf.Fee))
+func (payer *Payer) Payout() (Report, [][]byte, error) {
+	if payer.conf.Resume {
+		return payer.resume()
+	}
+
+	rewards, err := payer.report()
+	if err != nil {
+		return rewards, nil, err
+	}
+
+	return payer.payReport(rewards)
+}
+
+// payReport filters and redirects the payouts in the report, and pays them out unless the payout is a dry run
+func (payer *Payer) payReport(rewards Report) (Report, [][]byte, error) {
+	payer.filter(&rewards)
+	payer.redirect(&rewards)
+
+	responses := [][]byte{}
+	if !payer.conf.Dry {
+		if err := payer.checkLimits(rewards); err != nil {
+			return rewards, nil, err
+		}
+		if payer.signer != nil {
+			if err := payer.checkFunds(payer.signer.Address(), &rewards); err != nil {
+				return rewards, nil, err
+			}
+		}
+
+		entry, err := payer.checkLedger(rewards)
+		if err != nil {
+			return rewards, nil, err
+		}
+
+		responses, err = payer.pay(entry)
+		rewards.skip(entry.Skipped)
+		rewards.RevealFee = entry.RevealFee()
 		if err != nil {
-			return *rewards, nil, err
+			return rewards, responses, err
 		}
+	}
+
+	return rewards, responses, nil
+}
 
-		payments = rewards.GetPayments(payer.conf.PaymentMinimum)
+// report returns the rewards report for the cycle in the conf, or a report built from the
+// payments override if one was passed, so both go through the same filters
+func (payer *Payer) report() (Report, error) {
+	if len(payer.conf.PaymentsOverride.Payments) == 0 {
+		return payer.getReport(payer.conf.Cycle)
 	}
 
-	var delegations []goTezos.DelegationReport
-	for _, delegation := range rewards.Delegations {
-		intNet, _ := strconv.Atoi(delegation.NetRewards)
-		if intNet >= payer.conf.PaymentMinimum && !isInArray(payer.conf.Blacklist, delegation.DelegationPhk) {
-			delegations = append(delegations, delegation)
+	rewards := Report{Delegate: payer.conf.Delegate, Cycle: payer.conf.Cycle}
+	for _, payment := range payer.conf.PaymentsOverride.Payments {
+		amount := int64(math.Round(payment.Amount))
+		rewards.Payouts = append(rewards.Payouts, Payout{
+			Address: payment.Address,
+			Gross:   amount,
+			Net:     amount,
+		})
+	}
+
+	return rewards, nil
+}
+
+// filter removes blacklisted payouts from the report, and payouts that do not meet the payment
+// minimum, their gross rewards are withheld. With carry over, the rewards carried over from earlier
+// cycles are added to each payout, and payouts that still do not meet the payment minimum are
+// deferred to a later cycle instead of removed.
+func (payer *Payer) filter(rewards *Report) {
+	var filtered []Payout
+	for _, payout := range rewards.Payouts {
+		if isInArray(payer.conf.Blacklist, payout.Address) {
+			rewards.Withheld += payout.Gross
+			continue
+		}
+
+		if payer.conf.CarryOver && payer.ledger != nil {
+			payout.Carried = payer.ledger.Carried(rewards.Delegate, payout.Address, rewards.Cycle)
+		}
+
+		if payout.Net+payout.Carried >= int64(payer.conf.PaymentMinimum) {
+			filtered = append(filtered, payout)
+		} else if payer.conf.CarryOver {
+			payout.Deferred = true
+			filtered = append(filtered, payout)
+		} else {
+			rewards.Withheld += payout.Gross
 		}
 	}
 
-	rewards.Delegations = delegations
+	rewards.Payouts = filtered
+}
+
+// redirect sets the destination of payouts whose rewards should be paid to another address
+func (payer *Payer) redirect(rewards *Report) {
+	for i, payout := range rewards.Payouts {
+		if destination, ok := payer.conf.Redirects.Addresses[payout.Address]; ok && destination != payout.Address {
+			rewards.Payouts[i].Destination = destination
+		}
+	}
+}
+
+// resume finishes a payout recorded in the ledger, re-forging every batch that never reached the network
+func (payer *Payer) resume() (Report, [][]byte, error) {
+	rewards := Report{Delegate: payer.conf.Delegate, Cycle: payer.conf.Cycle}
+	if payer.ledger == nil {
+		return rewards, nil, fmt.Errorf("could not resume payout: no ledger")
+	}
 
+	entry := payer.ledger.Get(payer.conf.Delegate, payer.conf.Cycle)
+	if entry == nil {
+		return rewards, nil, fmt.Errorf("could not resume payout: no payout recorded for delegate %s at cycle %d", payer.conf.Delegate, payer.conf.Cycle)
+	}
+	if entry.Confirmed() {
+		return rewards, nil, fmt.Errorf("could not resume payout: cycle %d was already paid for delegate %s in %v", payer.conf.Cycle, payer.conf.Delegate, entry.OpHashes())
+	}
+
+	if payer.conf.Dry {
+		return rewards, nil, nil
+	}
+
+	responses, err := payer.pay(entry)
+	return rewards, responses, err
+}
+
+// pay forges and injects every batch of the entry that has not been injected yet, checkpointing
+// each batch in the ledger so a failed payout can be resumed. Batches are forged together with
+// a fresh counter and branch, then injected one by one. If confirmations are required, pay waits
+// for every injected batch to be confirmed and only returns the responses of confirmed batches.
+func (payer *Payer) pay(entry *ledger.Entry) ([][]byte, error) {
+	responses, err := payer.inject(entry)
+	if err != nil || payer.conf.Confirmations == 0 {
+		return responses, err
+	}
+
+	return payer.confirm(entry)
+}
+
+// inject forges and injects every batch of the entry that has not been injected yet. If the node
+// refuses some of the payments, they are skipped and recorded in the entry, and the rest are paid.
+// Batches signed before are only forged again once they are known not to have reached the network.
+func (payer *Payer) inject(entry *ledger.Entry) ([][]byte, error) {
 	responses := [][]byte{}
-	if !payer.conf.Dry {
-		ops, err := payer.gt.Operation.CreateBatchPayment(payments, payer.wallet, payer.conf.NetworkFee, payer.conf.NetworkGasLimit)
+
+	if err := payer.settle(entry); err != nil {
+		return responses, payer.record(entry, err)
+	}
+
+	pending, batches := pendingBatches(entry)
+	if len(pending) == 0 {
+		return responses, nil
+	}
+
+	prepared, ops, err := payer.forge(batches)
+	if isRefusal(err) {
+		skipped, skipErr := payer.skipRefused(entry)
+		if skipErr != nil {
+			err = skipErr
+		} else if skipped {
+			pending, batches = pendingBatches(entry)
+			prepared, ops, err = payer.forge(batches)
+		}
+	}
+	if err != nil {
+		for _, i := range pending {
+			entry.Batches[i].Status = ledger.StatusFailed
+			entry.Batches[i].Error = err.Error()
+		}
+		return responses, payer.record(entry, err)
+	}
+
+	for k, i := range pending {
+		entry.Batches[i].Operation = ops[k]
+		entry.Batches[i].RevealFee = prepared.Operations[k].RevealFee()
+		entry.Batches[i].OpHash = ""
+		entry.Batches[i].InjectedLevel = 0
+		entry.Batches[i].IncludedLevel = 0
+		entry.Batches[i].Status = ledger.StatusForged
+		entry.Batches[i].Error = ""
+	}
+	if err = payer.record(entry, nil); err != nil {
+		return responses, err
+	}
+
+	return payer.injectForged(entry)
+}
+
+// pendingBatches returns the indexes and payments of every batch of the entry that has not been injected yet
+func pendingBatches(entry *ledger.Entry) ([]int, [][]goTezos.Payment) {
+	var pending []int
+	var batches [][]goTezos.Payment
+	for i, batch := range entry.Batches {
+		if !batch.Injected() {
+			pending = append(pending, i)
+			payments := []goTezos.Payment{}
+			for _, payment := range batch.Payments {
+				payments = append(payments, payment.Payment())
+			}
+			batches = append(batches, payments)
+		}
+	}
+	return pending, batches
+}
+
+// injectForged injects every batch of the entry that was forged and signed, in order, recording each
+// one in the ledger as it is injected. It stops at the first batch that fails to inject. That batch
+// keeps its signed operation unless the node refused it, since it may still have reached the network,
+// and the batches after it, which were never sent, go back to pending.
+func (payer *Payer) injectForged(entry *ledger.Entry) ([][]byte, error) {
+	responses := [][]byte{}
+
+	head, err := payer.gt.Block.GetHead()
+	if err != nil {
+		return responses, err
+	}
+
+	for i, batch := range entry.Batches {
+		if batch.Status != ledger.StatusForged {
+			continue
+		}
+
+		resp, err := payer.gt.Operation.InjectOperation(batch.Operation)
 		if err != nil {
-			return *rewards, nil, err
+			entry.Batches[i].Status = ledger.StatusFailed
+			entry.Batches[i].Error = err.Error()
+			if refused(err) {
+				entry.Batches[i].Operation = ""
+			}
+			for k := i + 1; k < len(entry.Batches); k++ {
+				if entry.Batches[k].Status == ledger.StatusForged {
+					entry.Batches[k].Operation = ""
+					entry.Batches[k].Status = ledger.StatusPending
+				}
+			}
+			return responses, payer.record(entry, err)
+		}
+		responses = append(responses, resp)
+
+		entry.Batches[i].OpHash = strings.Trim(strings.TrimSpace(string(resp)), "\"")
+		entry.Batches[i].InjectedLevel = head.Header.Level
+		entry.Batches[i].Status = ledger.StatusInjected
+		if err = payer.record(entry, nil); err != nil {
+			return responses, err
+		}
+	}
+
+	return responses, nil
+}
+
+// confirm waits for every injected batch of the entry to be included on chain and confirmed, and
+// returns the responses of confirmed batches. Batches that were dropped or failed on chain are
+// recorded in the ledger so they can be paid again with --resume.
+func (payer *Payer) confirm(entry *ledger.Entry) ([][]byte, error) {
+	responses := [][]byte{}
+
+	var opHashes []string
+	from := -1
+	for _, batch := range entry.Batches {
+		if batch.Status == ledger.StatusInjected {
+			opHashes = append(opHashes, batch.OpHash)
+			if from == -1 || batch.InjectedLevel < from {
+				from = batch.InjectedLevel
+			}
+		}
+	}
+	if len(opHashes) == 0 {
+		return responses, nil
+	}
+
+	results, err := tracker.NewTracker(payer.gt, payer.conf.Confirmations).Wait(opHashes, from)
+	if err != nil {
+		return responses, err
+	}
+
+	var failures []string
+	for i, batch := range entry.Batches {
+		result, ok := results[batch.OpHash]
+		if batch.Status != ledger.StatusInjected || !ok {
+			continue
+		}
+
+		entry.Batches[i].IncludedLevel = result.Level
+		switch result.Status {
+		case tracker.StatusConfirmed:
+			entry.Batches[i].Status = ledger.StatusConfirmed
+			responses = append(responses, []byte(strconv.Quote(batch.OpHash)+"\n"))
+		case tracker.StatusFailed:
+			entry.Batches[i].Status = ledger.StatusFailed
+			entry.Batches[i].Error = result.Error
+			failures = append(failures, fmt.Sprintf("%s failed: %s", batch.OpHash, result.Error))
+		case tracker.StatusDropped:
+			entry.Batches[i].Status = ledger.StatusDropped
+			entry.Batches[i].Error = result.Error
+			failures = append(failures, fmt.Sprintf("%s dropped: %s", batch.OpHash, result.Error))
 		}
+	}
+
+	if len(failures) > 0 {
+		err = fmt.Errorf("operations were not confirmed, use --resume to pay them again: %s", strings.Join(failures, "; "))
+	}
+	return responses, payer.record(entry, err)
+}
+
+// checkLedger refuses to pay a delegate and cycle that the ledger shows as already paid,
+// unless the payout is forced, and checkpoints a new pending entry for the payout
+func (payer *Payer) checkLedger(rewards Report) (*ledger.Entry, error) {
+	entry := payer.newEntry(rewards)
+	if err := payer.checkPaid(entry); err != nil {
+		return entry, err
+	}
+
+	return entry, payer.record(entry, nil)
+}
 
-		for _, op := range ops {
-			resp, err := payer.gt.Operation.InjectOperation(op)
-			if err != nil {
-				return *rewards, responses, err
+// newEntry returns a pending ledger entry for the payments in the report
+func (payer *Payer) newEntry(rewards Report) *ledger.Entry {
+	return &ledger.Entry{
+		Delegate: rewards.Delegate,
+		Cycle:    rewards.Cycle,
+		Merged:   rewards.Cycles,
+		Batches:  payer.splitIntoBatches(rewards.Payments()),
+		Carry:    rewards.Carry(),
+		Created:  time.Now().UTC(),
+	}
+}
+
+// checkPaid returns an error if the ledger shows any cycle of the entry as already paid, unless the payout is forced
+func (payer *Payer) checkPaid(entry *ledger.Entry) error {
+	if payer.ledger == nil {
+		return nil
+	}
+
+	cycles := entry.Merged
+	if len(cycles) == 0 {
+		cycles = []int{entry.Cycle}
+	}
+	for _, cycle := range cycles {
+		previous := payer.ledger.Get(entry.Delegate, cycle)
+		if previous != nil && anyUnsettled(previous) {
+			err := payer.settle(previous)
+			if recordErr := payer.record(previous, nil); recordErr != nil {
+				return recordErr
+			}
+			if err != nil && !payer.conf.Force {
+				return fmt.Errorf("cycle %d may already be paid for delegate %s: %v", cycle, entry.Delegate, err)
 			}
-			responses = append(responses, resp)
+		}
+		if previous != nil && previous.Paid() && !payer.conf.Force {
+			if !previous.Complete() {
+				return fmt.Errorf("cycle %d was partially paid for delegate %s in %v, use --resume to finish the payout or --force to pay again", cycle, entry.Delegate, previous.OpHashes())
+			}
+			return fmt.Errorf("cycle %d was already paid for delegate %s in %v, use --force to pay again", cycle, entry.Delegate, previous.OpHashes())
 		}
 	}
 
-	return *rewards, responses, nil
+	return nil
+}
+
+// record writes the entry to the ledger and returns cause, or any error writing the ledger
+func (payer *Payer) record(entry *ledger.Entry, cause error) error {
+	if payer.ledger == nil {
+		return cause
+	}
+
+	err := payer.ledger.Put(entry)
+	if cause != nil {
+		return cause
+	}
+	return err
 }
 
 func isInArray(array []string, elem string) bool {
@@ -0,0 +1,320 @@
+package payer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+
+	"github.com/DefinitelyNotAGoat/payman/ledger"
+	"github.com/DefinitelyNotAGoat/payman/options"
+)
+
+// Report is the rewards report for a delegate's payout at a cycle. Every amount is in mutez.
+//
+// Rewards are shared out with integer arithmetic: each delegation's gross is its snapshot balance
+// over the delegate's staking balance times the cycle rewards, rounded down, and its fee is the gross
+// times the fee rate, rounded to the nearest mutez (halves up). The mutez lost by rounding the gross
+// down are the Remainder, which is kept by the baker or distributed to the delegations depending
+// on the remainder policy, so that the gross of every delegation plus Withheld, SelfBaked and
+// Remainder always adds up to CycleRewards.
+//
+// A report that merges several cycles lists them in Cycles, its Cycle is the first of them and its
+// amounts are the sums over every cycle merged.
+type Report struct {
+	Delegate       string
+	Cycle          int
+	Cycles         []int
+	CycleRewards   int64
+	StakingBalance int64
+	Payouts        []Payout
+	Withheld       int64
+	SelfBaked      int64
+	Remainder      int64
+	RevealFee      int64
+}
+
+// balanceJob is an address whose balance at a block should be fetched
+type balanceJob struct {
+	index   int
+	address string
+}
+
+// balanceJobResult is the balance of an address at a block
+type balanceJobResult struct {
+	index   int
+	balance int64
+	err     error
+}
+
+// TotalGross returns the sum of the gross rewards of every payout in the report
+func (r *Report) TotalGross() int64 {
+	var total int64
+	for _, payout := range r.Payouts {
+		total += payout.Gross
+	}
+	return total
+}
+
+// TotalFee returns the sum of the fees of every payout in the report
+func (r *Report) TotalFee() int64 {
+	var total int64
+	for _, payout := range r.Payouts {
+		total += payout.Fee
+	}
+	return total
+}
+
+// TotalNet returns the sum of the net rewards of every payout in the report
+func (r *Report) TotalNet() int64 {
+	var total int64
+	for _, payout := range r.Payouts {
+		total += payout.Net
+	}
+	return total
+}
+
+// Reconciles returns true if the report's amounts add up to the cycle rewards to the mutez
+func (r *Report) Reconciles() bool {
+	return r.TotalGross()+r.Withheld+r.SelfBaked+r.Remainder == r.CycleRewards
+}
+
+// TotalCarried returns the sum of the rewards carried over from earlier cycles and paid in the report
+func (r *Report) TotalCarried() int64 {
+	var total int64
+	for _, payout := range r.Payouts {
+		if !payout.Deferred {
+			total += payout.Carried
+		}
+	}
+	return total
+}
+
+// TotalPaid returns the sum of the amounts paid in the report
+func (r *Report) TotalPaid() int64 {
+	var total int64
+	for _, payout := range r.Payouts {
+		total += payout.Paid()
+	}
+	return total
+}
+
+// Carry returns the rewards carried over to later cycles by deferred payouts as positive amounts,
+// and the carried rewards paid out by the report as negative amounts, keyed by address
+func (r *Report) Carry() map[string]int64 {
+	carry := make(map[string]int64)
+	for _, payout := range r.Payouts {
+		if payout.Deferred && payout.Net != 0 {
+			carry[payout.Address] = payout.Net
+		} else if !payout.Deferred && payout.Carried != 0 {
+			carry[payout.Address] = -payout.Carried
+		}
+	}
+	if len(carry) == 0 {
+		return nil
+	}
+	return carry
+}
+
+// Payments converts the report into payments for batch pay, skipping payouts with nothing to pay.
+// Redirected payouts are paid to their destination.
+func (r *Report) Payments() []ledger.Payment {
+	payments := []ledger.Payment{}
+	for _, payout := range r.Payouts {
+		if payout.Paid() <= 0 {
+			continue
+		}
+
+		payment := ledger.Payment{Address: payout.Address, Amount: float64(payout.Paid())}
+		if payout.Destination != "" {
+			payment.Address = payout.Destination
+			payment.Delegation = payout.Address
+		}
+		payments = append(payments, payment)
+	}
+	return payments
+}
+
+// getReport calculates the rewards of every delegation of the delegate at cycle
+func (payer *Payer) getReport(cycle int) (Report, error) {
+	report := Report{Delegate: payer.conf.Delegate, Cycle: cycle}
+
+	strRewards, err := payer.gt.Delegate.GetRewards(payer.conf.Delegate, cycle)
+	if err != nil {
+		return report, fmt.Errorf("could not get rewards for %s at cycle %d: %v", payer.conf.Delegate, cycle, err)
+	}
+	report.CycleRewards, err = strconv.ParseInt(strRewards, 10, 64)
+	if err != nil {
+		return report, fmt.Errorf("could not parse rewards for %s at cycle %d: %v", payer.conf.Delegate, cycle, err)
+	}
+
+	strStakingBalance, err := payer.gt.Delegate.GetStakingBalanceAtCycle(payer.conf.Delegate, cycle)
+	if err != nil {
+		return report, fmt.Errorf("could not get staking balance for %s at cycle %d: %v", payer.conf.Delegate, cycle, err)
+	}
+	report.StakingBalance, err = strconv.ParseInt(strStakingBalance, 10, 64)
+	if err != nil {
+		return report, fmt.Errorf("could not parse staking balance for %s at cycle %d: %v", payer.conf.Delegate, cycle, err)
+	}
+
+	delegations, err := payer.gt.Delegate.GetDelegationsAtCycle(payer.conf.Delegate, cycle)
+	if err != nil {
+		return report, fmt.Errorf("could not get delegations for %s at cycle %d: %v", payer.conf.Delegate, cycle, err)
+	}
+
+	balances, err := payer.getBalancesAtSnapshot(delegations, cycle)
+	if err != nil {
+		return report, err
+	}
+
+	for i, delegation := range delegations {
+		report.Payouts = append(report.Payouts, Payout{Address: delegation, Balance: balances[i]})
+	}
+
+	report.share(payer.conf.Remainder)
+	for i := range report.Payouts {
+		rate, err := payer.feeRate(report.Payouts[i].Address, report.Payouts[i].Balance, cycle)
+		if err != nil {
+			return report, err
+		}
+		report.Payouts[i].charge(rate)
+	}
+
+	return report, nil
+}
+
+// share splits the cycle rewards between the delegations and the baker by balance, and assigns the
+// mutez lost to rounding according to the remainder policy. Shares are rounded down, towards minus
+// infinity, even when the rewards are negative.
+func (r *Report) share(policy string) {
+	if r.StakingBalance <= 0 {
+		r.SelfBaked = r.CycleRewards
+		return
+	}
+
+	rewards := big.NewInt(r.CycleRewards)
+	staking := big.NewInt(r.StakingBalance)
+	fractions := make([]*big.Int, len(r.Payouts))
+
+	var delegated int64
+	var shared int64
+	for i := range r.Payouts {
+		gross, fraction := new(big.Int).DivMod(new(big.Int).Mul(rewards, big.NewInt(r.Payouts[i].Balance)), staking, new(big.Int))
+		r.Payouts[i].Gross = gross.Int64()
+		r.Payouts[i].Share = float64(r.Payouts[i].Balance) / float64(r.StakingBalance)
+		fractions[i] = fraction
+		delegated += r.Payouts[i].Balance
+		shared += r.Payouts[i].Gross
+	}
+
+	self := r.StakingBalance - delegated
+	if self < 0 {
+		self = 0
+	}
+	r.SelfBaked = new(big.Int).Div(new(big.Int).Mul(rewards, big.NewInt(self)), staking).Int64()
+	r.Remainder = r.CycleRewards - shared - r.SelfBaked
+
+	if policy != options.RemainderDelegators || len(r.Payouts) == 0 {
+		return
+	}
+
+	// largest remainder first, ties broken by address so the result is deterministic
+	order := make([]int, len(r.Payouts))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		if c := fractions[order[a]].Cmp(fractions[order[b]]); c != 0 {
+			return c > 0
+		}
+		return r.Payouts[order[a]].Address < r.Payouts[order[b]].Address
+	})
+
+	for i := 0; r.Remainder > 0 && i < len(order); i++ {
+		r.Payouts[order[i]].Gross++
+		r.Remainder--
+	}
+}
+
+// charge applies the fee rate to the payout's gross rewards, rounding the fee to the nearest mutez
+func (p *Payout) charge(rate *big.Rat) {
+	num := new(big.Int).Mul(big.NewInt(p.Gross), rate.Num())
+	den := rate.Denom()
+
+	// (2 * gross * num + den) / (2 * den), divided rounding down, rounds halves up, negative fees too
+	fee := new(big.Int).Add(new(big.Int).Lsh(num, 1), den)
+	fee.Div(fee, new(big.Int).Lsh(den, 1))
+
+	p.Rate = rate
+	p.Fee = fee.Int64()
+	p.Net = p.Gross - p.Fee
+}
+
+// getBalancesAtSnapshot fetches the balance in mutez of every address at the snapshot for cycle
+func (payer *Payer) getBalancesAtSnapshot(addresses []string, cycle int) ([]int64, error) {
+	balances := make([]int64, len(addresses))
+	if len(addresses) == 0 {
+		return balances, nil
+	}
+
+	snapShot, err := payer.gt.SnapShot.Get(cycle)
+	if err != nil {
+		return balances, fmt.Errorf("could not get snapshot for cycle %d: %v", cycle, err)
+	}
+
+	return payer.getBalancesAtBlock(addresses, snapShot.AssociatedHash)
+}
+
+// getBalancesAtBlock fetches the balance in mutez of every address at the block with hash, 20 at a time
+func (payer *Payer) getBalancesAtBlock(addresses []string, hash string) ([]int64, error) {
+	balances := make([]int64, len(addresses))
+	jobs := make(chan balanceJob, len(addresses))
+	results := make(chan balanceJobResult, len(addresses))
+
+	for w := 1; w <= 20; w++ {
+		go func() {
+			for j := range jobs {
+				balance, err := payer.getBalanceAtBlock(j.address, hash)
+				results <- balanceJobResult{index: j.index, balance: balance, err: err}
+			}
+		}()
+	}
+
+	for i, address := range addresses {
+		jobs <- balanceJob{index: i, address: address}
+	}
+	close(jobs)
+
+	var err error
+	for range addresses {
+		result := <-results
+		if result.err != nil {
+			err = result.err
+		}
+		balances[result.index] = result.balance
+	}
+
+	return balances, err
+}
+
+// getBalanceAtBlock returns the balance of an address in mutez at the block with hash
+func (payer *Payer) getBalanceAtBlock(address, hash string) (int64, error) {
+	query := "/chains/main/blocks/" + hash + "/context/contracts/" + address + "/balance"
+	resp, err := payer.gt.Get(query, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not get balance '%s': %v", query, err)
+	}
+
+	var strBalance string
+	if err = json.Unmarshal(resp, &strBalance); err != nil {
+		return 0, fmt.Errorf("could not get balance '%s': %v", query, err)
+	}
+
+	balance, err := strconv.ParseInt(strBalance, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not get balance '%s': %v", query, err)
+	}
+
+	return balance, nil
+}
@@ -0,0 +1,164 @@
+package payer
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/DefinitelyNotAGoat/payman/options"
+)
+
+func TestShare(t *testing.T) {
+	cases := []struct {
+		name      string
+		rewards   int64
+		staking   int64
+		balances  []int64
+		policy    string
+		gross     []int64
+		selfBaked int64
+		remainder int64
+	}{
+		{
+			name:    "exact",
+			rewards: 3000, staking: 300, balances: []int64{100, 100},
+			policy: options.RemainderBaker,
+			gross:  []int64{1000, 1000}, selfBaked: 1000, remainder: 0,
+		},
+		{
+			name:    "remainder kept by the baker",
+			rewards: 10, staking: 7, balances: []int64{1, 2, 3},
+			policy: options.RemainderBaker,
+			gross:  []int64{1, 2, 4}, selfBaked: 1, remainder: 2,
+		},
+		{
+			name:    "remainder to the largest remainders",
+			rewards: 10, staking: 7, balances: []int64{1, 2, 3},
+			policy: options.RemainderDelegators,
+			gross:  []int64{2, 3, 4}, selfBaked: 1, remainder: 0,
+		},
+		{
+			name:    "remainder ties broken by address",
+			rewards: 100, staking: 300, balances: []int64{100, 100},
+			policy: options.RemainderDelegators,
+			gross:  []int64{34, 33}, selfBaked: 33, remainder: 0,
+		},
+		{
+			name:    "no self stake",
+			rewards: 100, staking: 3, balances: []int64{1, 1, 1},
+			policy: options.RemainderDelegators,
+			gross:  []int64{34, 33, 33}, selfBaked: 0, remainder: 0,
+		},
+		{
+			name:    "zero rewards",
+			rewards: 0, staking: 300, balances: []int64{100, 100},
+			policy: options.RemainderDelegators,
+			gross:  []int64{0, 0}, selfBaked: 0, remainder: 0,
+		},
+		{
+			name:    "negative rewards kept by the baker",
+			rewards: -100, staking: 300, balances: []int64{100, 100},
+			policy: options.RemainderBaker,
+			gross:  []int64{-34, -34}, selfBaked: -34, remainder: 2,
+		},
+		{
+			name:    "negative rewards to the delegators",
+			rewards: -100, staking: 300, balances: []int64{100, 100},
+			policy: options.RemainderDelegators,
+			gross:  []int64{-33, -33}, selfBaked: -34, remainder: 0,
+		},
+		{
+			name:    "no staking balance",
+			rewards: 100, staking: 0, balances: []int64{0},
+			policy: options.RemainderDelegators,
+			gross:  []int64{0}, selfBaked: 100, remainder: 0,
+		},
+		{
+			name:    "large amounts",
+			rewards: 123456789012, staking: 98765432109876, balances: []int64{12345678901234, 23456789012345, 34567890123456},
+			policy: options.RemainderDelegators,
+			gross:  []int64{15432098486, 29320985998, 43209862261}, selfBaked: 35493842267, remainder: 0,
+		},
+	}
+
+	addresses := []string{"tz1a", "tz1b", "tz1c"}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := Report{CycleRewards: c.rewards, StakingBalance: c.staking}
+			for i, balance := range c.balances {
+				r.Payouts = append(r.Payouts, Payout{Address: addresses[i], Balance: balance})
+			}
+
+			r.share(c.policy)
+
+			for i, payout := range r.Payouts {
+				if payout.Gross != c.gross[i] {
+					t.Errorf("gross of %s = %d, want %d", payout.Address, payout.Gross, c.gross[i])
+				}
+			}
+			if r.SelfBaked != c.selfBaked {
+				t.Errorf("SelfBaked = %d, want %d", r.SelfBaked, c.selfBaked)
+			}
+			if r.Remainder != c.remainder {
+				t.Errorf("Remainder = %d, want %d", r.Remainder, c.remainder)
+			}
+			if !r.Reconciles() {
+				t.Errorf("report does not reconcile: gross %d, self baked %d, remainder %d, rewards %d", r.TotalGross(), r.SelfBaked, r.Remainder, r.CycleRewards)
+			}
+		})
+	}
+}
+
+func TestCharge(t *testing.T) {
+	cases := []struct {
+		gross int64
+		rate  *big.Rat
+		fee   int64
+	}{
+		{gross: 1000, rate: big.NewRat(1, 10), fee: 100},
+		{gross: 14, rate: big.NewRat(1, 10), fee: 1},
+		{gross: 5, rate: big.NewRat(1, 10), fee: 1},
+		{gross: 15, rate: big.NewRat(1, 10), fee: 2},
+		{gross: 50, rate: big.NewRat(7, 100), fee: 4},
+		{gross: 1234567, rate: big.NewRat(1, 3), fee: 411522},
+		{gross: 1000, rate: big.NewRat(0, 1), fee: 0},
+		{gross: 1000, rate: big.NewRat(1, 1), fee: 1000},
+		{gross: 0, rate: big.NewRat(1, 10), fee: 0},
+		{gross: -5, rate: big.NewRat(1, 10), fee: 0},
+		{gross: -15, rate: big.NewRat(1, 10), fee: -1},
+		{gross: -16, rate: big.NewRat(1, 10), fee: -2},
+	}
+
+	for _, c := range cases {
+		p := Payout{Gross: c.gross}
+		p.charge(c.rate)
+		if p.Fee != c.fee || p.Net != c.gross-c.fee || p.Rate != c.rate {
+			t.Errorf("charge(%s) of %d = fee %d net %d, want fee %d net %d", c.rate.RatString(), c.gross, p.Fee, p.Net, c.fee, c.gross-c.fee)
+		}
+	}
+}
+
+func TestFilterReconciles(t *testing.T) {
+	for _, policy := range []string{options.RemainderBaker, options.RemainderDelegators} {
+		r := Report{CycleRewards: 1000001, StakingBalance: 9000}
+		for i, address := range []string{"tz1a", "tz1b", "tz1c", "tz1d"} {
+			r.Payouts = append(r.Payouts, Payout{Address: address, Balance: int64(1000*i + 7)})
+		}
+		r.share(policy)
+		for i := range r.Payouts {
+			r.Payouts[i].charge(big.NewRat(1, 10))
+		}
+
+		payer := Payer{conf: &options.Options{Blacklist: []string{"tz1b"}, PaymentMinimum: 10000}}
+		payer.filter(&r)
+
+		if len(r.Payouts) != 2 {
+			t.Errorf("%s: %d payouts left, want 2", policy, len(r.Payouts))
+		}
+		if r.Withheld == 0 {
+			t.Errorf("%s: nothing withheld", policy)
+		}
+		if !r.Reconciles() {
+			t.Errorf("%s: report does not reconcile: gross %d, withheld %d, self baked %d, remainder %d, rewards %d", policy, r.TotalGross(), r.Withheld, r.SelfBaked, r.Remainder, r.CycleRewards)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package payer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	goTezos "github.com/DefinitelyNotAGoat/go-tezos"
+)
+
+// revealed returns true if the public key of the address is revealed on chain, which it must be
+// before the address can send any operation other than a reveal
+func (payer *Payer) revealed(address string) (bool, error) {
+	resp, err := payer.gt.Get("/chains/main/blocks/head/context/contracts/"+address+"/manager_key", nil)
+	if err != nil {
+		return false, fmt.Errorf("could not get manager key of %s: %v", address, err)
+	}
+
+	// protocols from babylon on return the key or null, earlier ones the manager and the key if revealed
+	var key interface{}
+	if err = json.Unmarshal(resp, &key); err != nil {
+		return false, fmt.Errorf("could not get manager key of %s: %v", address, err)
+	}
+	switch key := key.(type) {
+	case string:
+		return key != "", nil
+	case map[string]interface{}:
+		return key["key"] != nil, nil
+	}
+	return false, nil
+}
+
+// revealContents returns the contents of a reveal of the public key of source
+func revealContents(source, publicKey string, counter int) Content {
+	return Content{
+		StructContents: goTezos.StructContents{
+			Kind:    "reveal",
+			Source:  source,
+			Counter: strconv.Itoa(counter),
+		},
+		PublicKey: publicKey,
+	}
+}
+
+// Reveal reveals the public key of the signer's wallet in an operation of its own, so payouts from
+// it need no reveal, and returns the response of the injection and the fee paid
+func (payer *Payer) Reveal() ([]byte, int64, error) {
+	if payer.signer == nil {
+		return nil, 0, fmt.Errorf("could not reveal wallet: no signer for the wallet")
+	}
+	source := payer.signer.Address()
+
+	revealed, err := payer.revealed(source)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not reveal wallet: %v", err)
+	}
+	if revealed {
+		return nil, 0, fmt.Errorf("could not reveal wallet: %s is already revealed", source)
+	}
+
+	publicKey, err := payer.signer.PublicKey()
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not reveal wallet: %v", err)
+	}
+
+	head, err := payer.gt.Block.GetHead()
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not reveal wallet: %v", err)
+	}
+	counter, err := payer.counter(source)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not reveal wallet: %v", err)
+	}
+
+	contents := []Content{revealContents(source, publicKey, counter+1)}
+	opBytes, err := payer.forgeEstimated(head, contents)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	prepared := &Prepared{
+		Version:    preparedVersion,
+		Source:     source,
+		Branch:     head.Hash,
+		Protocol:   head.Protocol,
+		Level:      head.Header.Level,
+		Operations: []Operation{{Contents: contents, Bytes: opBytes}},
+	}
+	if err = prepared.Sign(payer.signer); err != nil {
+		return nil, 0, err
+	}
+	if err = payer.preapply(prepared, prepared.Operations); err != nil {
+		return nil, 0, err
+	}
+	signed, err := prepared.Signed()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := payer.gt.Operation.InjectOperation(signed[0])
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not reveal wallet: %v", err)
+	}
+	return resp, prepared.TotalFees(), nil
+}
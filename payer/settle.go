@@ -0,0 +1,113 @@
+package payer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/DefinitelyNotAGoat/payman/ledger"
+	"github.com/DefinitelyNotAGoat/payman/tracker"
+	"github.com/Messer4/base58check"
+	"golang.org/x/crypto/blake2b"
+)
+
+var (
+	// prefixes of the base58check encodings of operation and block hashes
+	operationPrefix = []byte{5, 116}
+	blockPrefix     = []byte{1, 52}
+)
+
+// settle finds out what became of every batch of the entry that was signed but never recorded as
+// injected. An injection that timed out, or a crash before the ledger was written, may still have
+// put the operation on the network, and forging its payments again would pay them twice. Batches
+// found on chain are recorded as injected, or failed if they were not applied, and batches not
+// included before their branch expired as dropped. It returns an error if any batch may still be
+// included.
+func (payer *Payer) settle(entry *ledger.Entry) error {
+	for i, batch := range entry.Batches {
+		if !unsettled(batch) {
+			continue
+		}
+
+		opHash, branch, err := operationHash(batch.Operation)
+		if err != nil {
+			return fmt.Errorf("could not check batch %d reached the network: %v", i+1, err)
+		}
+		block, err := payer.gt.Block.Get(branch)
+		if err != nil {
+			return fmt.Errorf("could not check batch %d reached the network: %v", i+1, err)
+		}
+		results, err := tracker.NewTracker(payer.gt, 1).Check([]string{opHash}, block.Header.Level)
+		if err != nil {
+			return fmt.Errorf("could not check batch %d reached the network: %v", i+1, err)
+		}
+
+		result := results[opHash]
+		switch {
+		case result.Level > 0 && result.Status == tracker.StatusFailed:
+			entry.Batches[i].OpHash = opHash
+			entry.Batches[i].InjectedLevel = block.Header.Level
+			entry.Batches[i].IncludedLevel = result.Level
+			entry.Batches[i].Status = ledger.StatusFailed
+			entry.Batches[i].Error = result.Error
+		case result.Level > 0:
+			entry.Batches[i].OpHash = opHash
+			entry.Batches[i].InjectedLevel = block.Header.Level
+			entry.Batches[i].IncludedLevel = result.Level
+			entry.Batches[i].Status = ledger.StatusInjected
+			entry.Batches[i].Error = ""
+		case result.Status == tracker.StatusDropped:
+			entry.Batches[i].OpHash = opHash
+			entry.Batches[i].Status = ledger.StatusDropped
+			entry.Batches[i].Error = result.Error
+		default:
+			where := "may still be included"
+			if payer.inMempool(opHash) {
+				where = "is waiting in the node's mempool"
+			}
+			return fmt.Errorf("operation %s of batch %d %s until its branch at level %d expires, pay it again once it is included or dropped", opHash, i+1, where, block.Header.Level)
+		}
+	}
+	return nil
+}
+
+// unsettled returns true if the batch was signed but it is not known whether it reached the network
+func unsettled(batch ledger.Batch) bool {
+	return batch.Operation != "" && batch.OpHash == "" && (batch.Status == ledger.StatusForged || batch.Status == ledger.StatusFailed)
+}
+
+// anyUnsettled returns true if any batch of the entry is unsettled
+func anyUnsettled(entry *ledger.Entry) bool {
+	for _, batch := range entry.Batches {
+		if unsettled(batch) {
+			return true
+		}
+	}
+	return false
+}
+
+// operationHash returns the hash of a signed operation, in hex, and the hash of the block it was forged on
+func operationHash(signed string) (string, string, error) {
+	opBytes, err := hex.DecodeString(signed)
+	if err != nil || len(opBytes) < 32 {
+		return "", "", fmt.Errorf("could not decode operation %s", signed)
+	}
+
+	hash := blake2b.Sum256(opBytes)
+	opHash := base58check.Encode(append(append([]byte{}, operationPrefix...), hash[:]...))
+	branch := base58check.Encode(append(append([]byte{}, blockPrefix...), opBytes[:32]...))
+	return opHash, branch, nil
+}
+
+// inMempool returns true if the operation is in the node's mempool
+func (payer *Payer) inMempool(opHash string) bool {
+	resp, err := payer.gt.Get("/chains/main/mempool/pending_operations", nil)
+	return err == nil && bytes.Contains(resp, []byte(opHash))
+}
+
+// refused returns true if the node answered a request with its own errors, so it refused the operation,
+// rather than the request failing on the way to or from the node
+func refused(err error) bool {
+	return strings.Contains(err.Error(), "rpc error (") || strings.Contains(err.Error(), `"kind":`)
+}
@@ -0,0 +1,328 @@
+package payer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	goTezos "github.com/DefinitelyNotAGoat/go-tezos"
+	"github.com/DefinitelyNotAGoat/payman/signer"
+)
+
+const (
+	// minimalFees is the fee in mutez every operation pays bakers, on top of its size and gas
+	minimalFees = 100
+	// minimalNanotezPerByte is the fee bakers charge for each byte of an operation
+	minimalNanotezPerByte = 1000
+	// minimalNanotezPerGasUnit is the fee bakers charge for each unit of gas an operation may consume
+	minimalNanotezPerGasUnit = 100
+)
+
+// simulation is the response of run_operation for an operation
+type simulation struct {
+	Contents []struct {
+		Metadata struct {
+			OperationResult          simulationResult `json:"operation_result"`
+			InternalOperationResults []struct {
+				Result simulationResult `json:"result"`
+			} `json:"internal_operation_results"`
+		} `json:"metadata"`
+	} `json:"contents"`
+}
+
+// simulationResult is the result of a single transaction in a simulation
+type simulationResult struct {
+	Status                       string          `json:"status"`
+	ConsumedGas                  string          `json:"consumed_gas"`
+	PaidStorageSizeDiff          string          `json:"paid_storage_size_diff"`
+	AllocatedDestinationContract bool            `json:"allocated_destination_contract"`
+	Errors                       json.RawMessage `json:"errors"`
+}
+
+// forgeEstimated sets the gas limit, storage limit and fee of every transfer in contents and forges
+// them. Gas and storage are simulated with the node's run_operation helper, plus the simulation margin,
+// and fees are the minimal fees bakers accept for the operation's size and gas. If the node cannot
+// simulate the operation, the payout stops rather than pay fees nobody estimated, and only with
+// simulation off are the fixed network fee and gas limit used instead.
+func (payer *Payer) forgeEstimated(head goTezos.Block, contents []Content) (string, error) {
+	if payer.conf.Simulate {
+		sim, err := payer.simulate(head, contents)
+		if _, failed := err.(transferErrors); failed || err != nil && refused(err) {
+			return "", err
+		}
+		if err != nil {
+			return "", fmt.Errorf("%v, pass --simulate=false to pay the fixed --network-fee and --gas-limit instead", err)
+		}
+		return payer.forgeSimulated(head, contents, sim)
+	}
+
+	for i := range contents {
+		contents[i].Fee = strconv.Itoa(payer.conf.NetworkFee)
+		contents[i].GasLimit = strconv.Itoa(payer.conf.NetworkGasLimit)
+		contents[i].StorageLimit = strconv.Itoa(payer.gt.Constants.OriginationSize)
+	}
+	return payer.forgeContents(head.Hash, contents)
+}
+
+// forgeSimulated sets the limits of every transfer in contents from their simulation, then forges them
+// with the minimal fees for the forged size, forging again until the fees cover the size they add
+func (payer *Payer) forgeSimulated(head goTezos.Block, contents []Content, sim *simulation) (string, error) {
+	gas := make([]int64, len(contents))
+	for i := range contents {
+		consumed, storage := simulated(sim, i, payer.gt.Constants.OriginationSize)
+		gas[i] = withMargin(consumed, payer.conf.SimulationMargin)
+		contents[i].GasLimit = strconv.FormatInt(gas[i], 10)
+		contents[i].StorageLimit = strconv.FormatInt(withMargin(storage, payer.conf.SimulationMargin), 10)
+		contents[i].Fee = strconv.Itoa(payer.conf.NetworkFee)
+	}
+
+	size := 0
+	for attempt := 0; attempt < 3; attempt++ {
+		opBytes, err := payer.forgeContents(head.Hash, contents)
+		if err != nil {
+			return "", err
+		}
+
+		// the operation is signed with a 64 byte signature after it is forged
+		forged := len(opBytes)/2 + 64
+		if forged <= size {
+			return opBytes, nil
+		}
+		size = forged
+		setFees(contents, gas, size)
+	}
+	return "", fmt.Errorf("could not forge operation: fees did not settle")
+}
+
+// setFees shares the minimal fees of an operation of size bytes out between its transfers, each paying
+// for its own gas and an equal part of the size, and the first paying the fixed fee and what is left over
+func setFees(contents []Content, gas []int64, size int) {
+	n := int64(len(contents))
+	bytesFee := ceilDiv(int64(size)*minimalNanotezPerByte, 1000)
+	for i := range contents {
+		fee := ceilDiv(gas[i]*minimalNanotezPerGasUnit, 1000) + bytesFee/n
+		if i == 0 {
+			fee += minimalFees + bytesFee%n
+		}
+		contents[i].Fee = strconv.FormatInt(fee, 10)
+	}
+}
+
+// simulate runs the transfers in contents with the node's run_operation helper, with the most gas and
+// storage an operation may use and no fees. It returns a transferErrors if any transfer would fail,
+// and a refusalError if the node refuses the operation outright for reasons other than the paying wallet's.
+func (payer *Payer) simulate(head goTezos.Block, contents []Content) (*simulation, error) {
+	hardGas, err := strconv.ParseInt(payer.gt.Constants.HardGasLimitPerOperation, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("could not simulate operation: invalid hard gas limit '%s'", payer.gt.Constants.HardGasLimitPerOperation)
+	}
+	if blockGas, err := strconv.ParseInt(payer.gt.Constants.HardGasLimitPerBlock, 10, 64); err == nil && blockGas/int64(len(contents)) < hardGas {
+		hardGas = blockGas / int64(len(contents))
+	}
+
+	simulated := make([]Content, len(contents))
+	for i, content := range contents {
+		content.Fee = "0"
+		content.GasLimit = strconv.FormatInt(hardGas, 10)
+		content.StorageLimit = payer.gt.Constants.HardStorageLimitPerOperation
+		simulated[i] = content
+	}
+
+	type operation struct {
+		Branch    string    `json:"branch"`
+		Contents  []Content `json:"contents"`
+		Signature string    `json:"signature"`
+	}
+	op := operation{Branch: head.Hash, Contents: simulated, Signature: signer.Empty()}
+
+	// protocols from babylon on take the chain id with the operation, earlier ones the operation alone
+	args, _ := json.Marshal(struct {
+		Operation operation `json:"operation"`
+		ChainID   string    `json:"chain_id"`
+	}{op, head.ChainID})
+	resp, err := payer.gt.Post("/chains/main/blocks/head/helpers/scripts/run_operation", string(args))
+	if err != nil {
+		args, _ = json.Marshal(op)
+		var legacyErr error
+		if resp, legacyErr = payer.gt.Post("/chains/main/blocks/head/helpers/scripts/run_operation", string(args)); legacyErr != nil {
+			return nil, nodeRefusal(fmt.Errorf("could not simulate operation: %v", err), source(contents))
+		}
+	}
+
+	var sim simulation
+	if err = json.Unmarshal(resp, &sim); err != nil {
+		return nil, fmt.Errorf("could not simulate operation: %v", err)
+	}
+	if len(sim.Contents) != len(contents) {
+		return nil, fmt.Errorf("could not simulate operation: %d results for %d transfers", len(sim.Contents), len(contents))
+	}
+
+	var failed transferErrors
+	for i, content := range sim.Contents {
+		if result := content.Metadata.OperationResult; result.Status == "failed" {
+			failed = append(failed, newTransferError(contents[i], result.Errors))
+		}
+	}
+	if len(failed) > 0 {
+		return nil, failed
+	}
+
+	return &sim, nil
+}
+
+// simulated returns the gas consumed and the storage paid for by transfer i of a simulation, including
+// any operations it made internally and the storage of allocating an empty destination
+func simulated(sim *simulation, i int, originationSize int) (int64, int64) {
+	metadata := sim.Contents[i].Metadata
+	results := []simulationResult{metadata.OperationResult}
+	for _, internal := range metadata.InternalOperationResults {
+		results = append(results, internal.Result)
+	}
+
+	var gas, storage int64
+	for _, result := range results {
+		consumed, _ := strconv.ParseInt(result.ConsumedGas, 10, 64)
+		paid, _ := strconv.ParseInt(result.PaidStorageSizeDiff, 10, 64)
+		gas += consumed
+		storage += paid
+		if result.AllocatedDestinationContract {
+			storage += int64(originationSize)
+		}
+	}
+	return gas, storage
+}
+
+// transferError is a transfer the node refused in a simulation or preapply
+type transferError struct {
+	Destination string
+	Amount      string
+	Errors      string
+	// Refused is whether the recipient refused the transfer, rather than the paying wallet failing it
+	Refused bool
+}
+
+// newTransferError returns the transfer error of a content the node refused with errors, naming
+// each error by its id
+func newTransferError(content Content, errors json.RawMessage) transferError {
+	var ids []struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(errors, &ids)
+
+	reasons := []string{}
+	for _, id := range ids {
+		reasons = append(reasons, id.ID)
+	}
+	if len(reasons) == 0 {
+		reasons = append(reasons, string(errors))
+	}
+	return transferError{
+		Destination: content.Destination,
+		Amount:      content.Amount,
+		Errors:      strings.Join(reasons, ", "),
+		Refused:     recipientRefused(string(errors), content.Source, content.Destination),
+	}
+}
+
+// transferErrors are the transfers of an operation the node refused in a simulation or preapply
+type transferErrors []transferError
+
+func (t transferErrors) Error() string {
+	failures := make([]string, len(t))
+	for i, failure := range t {
+		failures[i] = fmt.Sprintf("transfer of %s mutez to %s would fail: %s", failure.Amount, failure.Destination, failure.Errors)
+	}
+	return "node refused operation: " + strings.Join(failures, "; ")
+}
+
+// refused returns true if every transfer failed because its recipient refused it
+func (t transferErrors) refused() bool {
+	for _, failure := range t {
+		if !failure.Refused {
+			return false
+		}
+	}
+	return true
+}
+
+// refusalError is the node refusing to forge or preapply an operation, with the node's own errors
+type refusalError struct {
+	error
+}
+
+// nodeRefusal returns err as a refusalError if it is the node refusing an operation from source for
+// reasons other than the paying wallet's own, and err as it is otherwise
+func nodeRefusal(err error, source string) error {
+	if refused(err) && !walletFailed(err.Error(), source) {
+		return refusalError{err}
+	}
+	return err
+}
+
+// isRefusal returns true if err is the node refusing an operation or some of its transfers because
+// of their recipients, rather than the node or the signer failing to answer or the paying wallet
+// being unable to make them, such as when it cannot afford them
+func isRefusal(err error) bool {
+	switch e := err.(type) {
+	case transferErrors:
+		return e.refused()
+	case refusalError:
+		return true
+	}
+	return false
+}
+
+// walletFailures are the ids of errors the paying wallet causes itself, whoever it pays
+var walletFailures = []string{
+	"balance_too_low", "counter_in_the_past", "counter_in_the_future", "unrevealed_key", "previously_revealed_key",
+	"inconsistent_hash", "inconsistent_public_key", "empty_implicit_contract", "gas_exhausted", "gas_limit_too_high",
+	"storage_exhausted", "storage_limit_too_high", "cannot_pay_storage_fee",
+}
+
+// recipientFailures are the ids, or the start of the ids, of errors the recipient of a transfer causes,
+// such as its script rejecting the transfer
+var recipientFailures = []string{
+	"michelson_v1.", "non_existing_contract", "unspendable_contract", "invalid_contract_notation",
+}
+
+// walletFailed returns true if the node's errors name the paying wallet source, or are one of its own failures
+func walletFailed(errors, source string) bool {
+	if source != "" && strings.Contains(errors, source) {
+		return true
+	}
+	for _, id := range walletFailures {
+		if strings.Contains(errors, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// recipientRefused returns true if the node's errors for a transfer from source name its destination
+// or are an error of the recipient, and are not the paying wallet's own failures
+func recipientRefused(errors, source, destination string) bool {
+	if walletFailed(errors, source) {
+		return false
+	}
+	if destination != "" && strings.Contains(errors, destination) {
+		return true
+	}
+	for _, id := range recipientFailures {
+		if strings.Contains(errors, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// withMargin returns amount plus the margin, a fraction of it, rounded up
+func withMargin(amount int64, margin float64) int64 {
+	return int64(math.Ceil(float64(amount) * (1 + margin)))
+}
+
+// ceilDiv returns a divided by b rounded up
+func ceilDiv(a, b int64) int64 {
+	return (a + b - 1) / b
+}
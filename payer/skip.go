@@ -0,0 +1,126 @@
+package payer
+
+import (
+	goTezos "github.com/DefinitelyNotAGoat/go-tezos"
+	"github.com/DefinitelyNotAGoat/payman/ledger"
+)
+
+// skipRefused finds the payments of the entry's pending batches that the node refuses, and takes them
+// out of their batches into the entry's skipped payments, so everyone else can still be paid. Rewards
+// carried over to a skipped payment stay carried over. It returns false, leaving the entry as it was,
+// if no payment was refused or if every payment was, since then the payout itself is at fault, and
+// an error if the node or the signer failed while the payments were tried.
+func (payer *Payer) skipRefused(entry *ledger.Entry) (bool, error) {
+	var batches []ledger.Batch
+	var skipped []ledger.Skipped
+	var pending int
+	for _, batch := range entry.Batches {
+		if batch.Injected() {
+			batches = append(batches, batch)
+			continue
+		}
+
+		pending += len(batch.Payments)
+		accepted, refused, err := payer.isolate(batch.Payments)
+		if err != nil {
+			return false, err
+		}
+		skipped = append(skipped, refused...)
+		if len(accepted) > 0 {
+			batch.Payments = accepted
+			batches = append(batches, batch)
+		}
+	}
+	if len(skipped) == 0 || len(skipped) == pending {
+		return false, nil
+	}
+
+	entry.Batches = batches
+	entry.Skipped = append(entry.Skipped, skipped...)
+	for _, skip := range skipped {
+		address := skip.Address
+		if skip.Delegation != "" {
+			address = skip.Delegation
+		}
+		if entry.Carry[address] < 0 {
+			delete(entry.Carry, address)
+		}
+	}
+	return true, nil
+}
+
+// skip marks the payouts of the report that were skipped with the reason the node refused them
+func (r *Report) skip(skipped []ledger.Skipped) {
+	for _, skip := range skipped {
+		for i, payout := range r.Payouts {
+			if destination(payout) == skip.Address && (skip.Delegation == "" || skip.Delegation == payout.Address) {
+				r.Payouts[i].Skipped = skip.Reason
+			}
+		}
+	}
+}
+
+// isolate returns the payments of a batch the node accepts, and the payments it refuses with its
+// reasons. Recipients the node names are skipped straight away, otherwise the batch is split in halves
+// until every payment refused is on its own. Only the node refusing payments because of their
+// recipients skips them: any other error, such as the node or the signer failing to answer or the
+// paying wallet being unable to afford the payments, is returned and nothing is skipped.
+func (payer *Payer) isolate(payments []ledger.Payment) ([]ledger.Payment, []ledger.Skipped, error) {
+	err := payer.try(payments)
+	if err == nil {
+		return payments, nil, nil
+	}
+	if !isRefusal(err) {
+		return nil, nil, err
+	}
+
+	if failed, ok := err.(transferErrors); ok {
+		reasons := make(map[string]string)
+		for _, failure := range failed {
+			reasons[failure.Destination] = failure.Errors
+		}
+
+		var rest []ledger.Payment
+		var skipped []ledger.Skipped
+		for _, payment := range payments {
+			if reason, ok := reasons[payment.Address]; ok {
+				skipped = append(skipped, ledger.Skipped{Payment: payment, Reason: reason})
+			} else {
+				rest = append(rest, payment)
+			}
+		}
+		if len(skipped) > 0 {
+			if len(rest) == 0 {
+				return nil, skipped, nil
+			}
+			accepted, refused, err := payer.isolate(rest)
+			return accepted, append(skipped, refused...), err
+		}
+	}
+
+	if len(payments) == 1 {
+		return nil, []ledger.Skipped{{Payment: payments[0], Reason: err.Error()}}, nil
+	}
+
+	half := len(payments) / 2
+	accepted, refused, err := payer.isolate(payments[:half])
+	if err != nil {
+		return nil, nil, err
+	}
+	moreAccepted, moreRefused, err := payer.isolate(payments[half:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(append([]ledger.Payment{}, accepted...), moreAccepted...), append(refused, moreRefused...), nil
+}
+
+// try forges, signs and preapplies the payments as a batch of their own, and returns why the node refuses them
+func (payer *Payer) try(payments []ledger.Payment) error {
+	batch := make([]goTezos.Payment, len(payments))
+	for i, payment := range payments {
+		batch[i] = payment.Payment()
+	}
+
+	_, _, err := payer.forge([][]goTezos.Payment{batch})
+	return err
+}
@@ -2,7 +2,7 @@ package reddit
 
 import (
 	"fmt"
-	"strconv"
+	"strings"
 
 	"github.com/turnage/graw/reddit"
 )
@@ -24,11 +24,11 @@ func NewRedditSession(agentFile, sub, title string) (*Bot, error) {
 	return &Bot{sub: sub, title: title, session: reddit}, nil
 }
 
-// Post posts a tzscan link to the ophash
-func (bot *Bot) Post(ophash string, cycle int) error {
+// Post posts a tzscan link to the ophash, paying out cycles formatted as a list (e.g. 488,489,490)
+func (bot *Bot) Post(ophash string, cycles string) error {
 	ophash = ophash[1 : len(ophash)-2]
 	link := "https://tzscan.io/" + ophash
-	title := bot.title + fmt.Sprintf(" Payout for Cycle "+strconv.Itoa(cycle))
+	title := bot.title + fmt.Sprintf(" Payout for %s %s", cycleLabel(cycles), cycles)
 	err := bot.session.PostLink(bot.sub, title, link)
 	if err != nil {
 		return err
@@ -36,3 +36,11 @@ func (bot *Bot) Post(ophash string, cycle int) error {
 
 	return nil
 }
+
+// cycleLabel returns Cycles if cycles lists several cycles, and Cycle if it is one
+func cycleLabel(cycles string) string {
+	if strings.Contains(cycles, ",") {
+		return "Cycles"
+	}
+	return "Cycle"
+}
@@ -2,14 +2,19 @@ package reporting
 
 import (
 	"fmt"
+	"io"
 	"log"
+	"math/big"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"encoding/csv"
 
 	goTezos "github.com/DefinitelyNotAGoat/go-tezos"
+	"github.com/DefinitelyNotAGoat/payman/options"
+	pay "github.com/DefinitelyNotAGoat/payman/payer"
 	"github.com/olekukonko/tablewriter"
 )
 
@@ -36,7 +41,7 @@ func NewReporter(general *log.Logger) (Reporter, error) {
 }
 
 // PrintPaymentsTable takes in payments and prints them to a table for general logging
-func (r *Reporter) PrintPaymentsTable(payments goTezos.DelegateReport) {
+func (r *Reporter) PrintPaymentsTable(payments pay.Report) {
 	total := []string{}
 	data := r.formatData(payments)
 	if len(data) > 0 {
@@ -45,46 +50,191 @@ func (r *Reporter) PrintPaymentsTable(payments goTezos.DelegateReport) {
 	}
 
 	table := tablewriter.NewWriter(r.general.Writer())
-	table.SetHeader([]string{"Address", "Share", "Gross", "Fee", "Net"})
+	table.SetHeader([]string{"Address", "Paid To", "Balance", "Share", "Fee Rate", "Gross", "Fee", "Net", "Carried", "Paid"})
 	table.SetFooter(total)
 
 	for _, v := range data {
 		table.Append(v)
 	}
 	table.Render()
+
+	for _, payout := range payments.Payouts {
+		if payout.Skipped != "" {
+			r.Log(fmt.Sprintf("warning: skipped paying %s XTZ to %s, follow it up by hand, the node refused it: %s", formatMutez(payout.Net+payout.Carried), payout.Address, payout.Skipped))
+		}
+	}
+
+	if payments.RevealFee > 0 {
+		r.Log(fmt.Sprintf("revealed the public key of the payout wallet with the payout, for a fee of %s XTZ", formatMutez(payments.RevealFee)))
+	}
+
+	if payments.CycleRewards > 0 {
+		r.printSummaryTable(payments)
+	}
+}
+
+// printSummaryTable prints how the cycle rewards of a report were shared out, so the report can be
+// reconciled against the rewards to the mutez
+func (r *Reporter) printSummaryTable(payments pay.Report) {
+	table := tablewriter.NewWriter(r.general.Writer())
+	table.SetHeader([]string{"Cycle Rewards", "Paid Gross", "Withheld", "Baker", "Remainder"})
+	table.Append([]string{
+		formatMutez(payments.CycleRewards),
+		formatMutez(payments.TotalGross()),
+		formatMutez(payments.Withheld),
+		formatMutez(payments.SelfBaked),
+		formatMutez(payments.Remainder),
+	})
+	table.Render()
+
+	if !payments.Reconciles() {
+		r.Log(fmt.Sprintf("warning: report for cycle %d does not reconcile with cycle rewards %s", payments.Cycle, formatMutez(payments.CycleRewards)))
+	}
+}
+
+// PrintDelegatesSummaryTable prints a row for each report and the totals across every report, for
+// invocations that pay out several delegates or cycles at once
+func (r *Reporter) PrintDelegatesSummaryTable(reports []pay.Report) {
+	table := tablewriter.NewWriter(r.general.Writer())
+	table.SetHeader([]string{"Delegate", "Cycle", "Cycle Rewards", "Gross", "Fee", "Paid", "Payments"})
+
+	var rewards, gross, fee, paid int64
+	var payments int
+	for _, report := range reports {
+		count := len(report.Payments())
+		table.Append([]string{
+			report.Delegate,
+			FormatCycles(report),
+			formatMutez(report.CycleRewards),
+			formatMutez(report.TotalGross()),
+			formatMutez(report.TotalFee()),
+			formatMutez(report.TotalPaid()),
+			strconv.Itoa(count),
+		})
+		rewards += report.CycleRewards
+		gross += report.TotalGross()
+		fee += report.TotalFee()
+		paid += report.TotalPaid()
+		payments += count
+	}
+
+	table.SetFooter([]string{"", "Total", formatMutez(rewards), formatMutez(gross), formatMutez(fee), formatMutez(paid), strconv.Itoa(payments)})
+	table.Render()
+}
+
+// PrintPreparedSummary prints every transfer of a prepared payout and its totals to w, so the payout
+// can be checked before it is signed or broadcast
+func PrintPreparedSummary(w io.Writer, prepared *pay.Prepared) {
+	cycles := FormatCycles(pay.Report{Cycle: prepared.Entry.Cycle, Cycles: prepared.Entry.Merged})
+	fmt.Fprintf(w, "payout for %s at cycle %s from %s, forged at level %d on %s\n", prepared.Entry.Delegate, cycles, prepared.Source, prepared.Level, prepared.Branch)
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Operation", "Recipient", "Amount", "Fee", "Gas Limit", "Signed"})
+
+	var recipients int
+	for i, op := range prepared.Operations {
+		signed := "no"
+		if op.Signature != "" {
+			signed = "yes"
+		}
+
+		for _, content := range op.Contents {
+			amount, _ := strconv.ParseInt(content.Amount, 10, 64)
+			fee, _ := strconv.ParseInt(content.Fee, 10, 64)
+			if content.Kind == "reveal" {
+				table.Append([]string{strconv.Itoa(i + 1), "reveal " + content.Source, "", formatMutez(fee), content.GasLimit, signed})
+				continue
+			}
+			table.Append([]string{strconv.Itoa(i + 1), content.Destination, formatMutez(amount), formatMutez(fee), content.GasLimit, signed})
+			recipients++
+		}
+	}
+
+	table.SetFooter([]string{"Total", fmt.Sprintf("%d recipients", recipients), formatMutez(prepared.TotalAmount()), formatMutez(prepared.TotalFees()), "", ""})
+	table.Render()
+
+	fmt.Fprintf(w, "total debited from %s: %s XTZ in %d operations\n", prepared.Source, formatMutez(prepared.TotalAmount()+prepared.TotalFees()), len(prepared.Operations))
+}
+
+// PrintOverrideSummary prints every payment read from a payments override file and their total to w,
+// so the payments can be checked before they are paid
+func PrintOverrideSummary(w io.Writer, override options.PaymentsOverride) {
+	fmt.Fprintf(w, "payments override from %s\n", override.File)
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Address", "Amount (XTZ)", "Memo"})
+
+	var total int64
+	for _, payment := range override.Payments {
+		amount := int64(payment.Amount)
+		total += amount
+		table.Append([]string{payment.Address, formatMutez(amount), override.Memos[payment.Address]})
+	}
+
+	table.SetFooter([]string{fmt.Sprintf("%d payments", len(override.Payments)), formatMutez(total), ""})
+	table.Render()
 }
 
 // formatData parses payments into a double array of data for table or csv printing
-func (r *Reporter) formatData(payments goTezos.DelegateReport) [][]string {
+func (r *Reporter) formatData(payments pay.Report) [][]string {
 	var data [][]string
-	var totalNet float64
-	var totalGross float64
-	var totalFee float64
-	for _, payment := range payments.Delegations {
+	for _, payment := range payments.Payouts {
 		share := payment.Share * 100
 		strShare := fmt.Sprintf("%.6f", share)
-		fee, _ := strconv.Atoi(payment.Fee)
-		floatFee := float64(fee) / float64(goTezos.MUTEZ)
-		gross, _ := strconv.Atoi(payment.GrossRewards)
-		floatGross := float64(gross) / float64(goTezos.MUTEZ)
-		net, _ := strconv.Atoi(payment.NetRewards)
-		floatNet := float64(net) / float64(goTezos.MUTEZ)
-
-		totalNet = totalNet + floatNet
-		totalGross = totalGross + floatGross
-		totalFee = totalFee + floatFee
-		data = append(data, []string{payment.DelegationPhk, strShare, fmt.Sprintf("%.6f", floatGross), fmt.Sprintf("%.6f", floatFee), fmt.Sprintf("%.6f", floatNet)})
-	}
-	data = append(data, []string{"", "Total", fmt.Sprintf("%.6f", totalGross), fmt.Sprintf("%.6f", totalFee), fmt.Sprintf("%.6f", totalNet)})
+		paid := formatMutez(payment.Paid())
+		if payment.Deferred {
+			paid = "deferred"
+		} else if payment.Skipped != "" {
+			paid = "skipped"
+		}
+		data = append(data, []string{payment.Address, payment.Destination, formatMutez(payment.Balance), strShare, formatRate(payment.Rate), formatMutez(payment.Gross), formatMutez(payment.Fee), formatMutez(payment.Net), formatMutez(payment.Carried), paid})
+	}
+	data = append(data, []string{"", "", "", "", "Total", formatMutez(payments.TotalGross()), formatMutez(payments.TotalFee()), formatMutez(payments.TotalNet()), formatMutez(payments.TotalCarried()), formatMutez(payments.TotalPaid())})
 	return data
 }
 
-// WriteCSVReport writes payments to a csv file for reporting
-func (r *Reporter) WriteCSVReport(payments goTezos.DelegateReport) {
+// FormatCycles formats the cycle of a report, or every cycle it merged (e.g. 488,489,490)
+func FormatCycles(report pay.Report) string {
+	if len(report.Cycles) == 0 {
+		return strconv.Itoa(report.Cycle)
+	}
+
+	cycles := make([]string, len(report.Cycles))
+	for i, cycle := range report.Cycles {
+		cycles[i] = strconv.Itoa(cycle)
+	}
+	return strings.Join(cycles, ",")
+}
+
+// formatRate formats a fee rate as a percentage, or an empty string if no fee was charged
+func formatRate(rate *big.Rat) string {
+	if rate == nil {
+		return ""
+	}
+	return new(big.Rat).Mul(rate, big.NewRat(100, 1)).FloatString(2) + "%"
+}
+
+// formatMutez formats an amount in mutez as an exact amount of XTZ
+func formatMutez(mutez int64) string {
+	sign := ""
+	if mutez < 0 {
+		sign = "-"
+		mutez = -mutez
+	}
+	return fmt.Sprintf("%s%d.%06d", sign, mutez/goTezos.MUTEZ, mutez%goTezos.MUTEZ)
+}
+
+// WriteCSVReport writes payments to a csv file for reporting, with the reason any payment was skipped
+// in a last column
+func (r *Reporter) WriteCSVReport(payments pay.Report) {
 	data := r.formatData(payments)
 	if r.report != nil {
-		for _, value := range data {
-			r.report.Write(value)
+		for i, value := range data {
+			var skipped string
+			if i < len(payments.Payouts) {
+				skipped = payments.Payouts[i].Skipped
+			}
+			r.report.Write(append(value, skipped))
 		}
 	}
 	r.report.Flush()
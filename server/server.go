@@ -5,85 +5,163 @@ import (
 	"time"
 
 	goTezos "github.com/DefinitelyNotAGoat/go-tezos"
+	"github.com/DefinitelyNotAGoat/payman/ledger"
 	"github.com/DefinitelyNotAGoat/payman/options"
 	pay "github.com/DefinitelyNotAGoat/payman/payer"
 	"github.com/DefinitelyNotAGoat/payman/reddit"
 	"github.com/DefinitelyNotAGoat/payman/reporting"
+	"github.com/DefinitelyNotAGoat/payman/signer"
 	"github.com/DefinitelyNotAGoat/payman/twitter"
 )
 
 // PayoutServer is structure representing a payout server
 type PayoutServer struct {
-	gt       *goTezos.GoTezos
-	wallet   goTezos.Wallet
-	reporter reporting.Reporter
-	rbot     *reddit.Bot
-	tbot     *twitter.Bot
-	conf     *options.Options
+	gt        *goTezos.GoTezos
+	ledger    *ledger.Ledger
+	reporter  reporting.Reporter
+	rbot      *reddit.Bot
+	tbot      *twitter.Bot
+	delegates []Delegate
 }
 
-// NewPayoutServer contructs a new payout server
-func NewPayoutServer(gt *goTezos.GoTezos, wallet goTezos.Wallet, reporter reporting.Reporter, rbot *reddit.Bot, tbot *twitter.Bot, conf *options.Options) PayoutServer {
+// Delegate is a delegate paid out by the server and the signer of the wallet it pays from
+type Delegate struct {
+	Signer signer.Signer
+	Conf   *options.Options
+}
+
+// NewPayoutServer contructs a new payout server for one or more delegates
+func NewPayoutServer(gt *goTezos.GoTezos, ledger *ledger.Ledger, reporter reporting.Reporter, rbot *reddit.Bot, tbot *twitter.Bot, delegates []Delegate) PayoutServer {
 	return PayoutServer{
-		gt:       gt,
-		wallet:   wallet,
-		reporter: reporter,
-		rbot:     rbot,
-		tbot:     tbot,
-		conf:     conf,
+		gt:        gt,
+		ledger:    ledger,
+		reporter:  reporter,
+		rbot:      rbot,
+		tbot:      tbot,
+		delegates: delegates,
 	}
 }
 
-// Serve starts the payout server
+// Serve starts the payout server. Every time the head cycle changes, the server pays out every cycle
+// between the last cycle paid in the ledger and the latest payable cycle for each delegate, one payout
+// per cycle or with merge a single payout for all of them. A delegate whose payout fails is not paid
+// out again until the server is restarted, the others carry on.
 func (ps *PayoutServer) Serve() {
-	ticker := time.NewTicker(5 * time.Minute)
-	quit := make(chan struct{})
-	head, err := ps.gt.Block.GetHead()
-	if err != nil {
-		ps.reporter.Log(err)
+	payers := make([]pay.Payer, len(ps.delegates))
+	next := make([]int, len(ps.delegates))
+	stopped := make([]bool, len(ps.delegates))
+	for i, d := range ps.delegates {
+		payers[i] = pay.NewPayer(ps.gt, d.Signer, ps.ledger, d.Conf)
+
+		var err error
+		next[i], err = ps.firstCycle(d.Conf)
+		if err != nil {
+			ps.reporter.Log(err)
+			return
+		}
+		ps.reporter.Log(fmt.Sprintf("paying out cycles from %d for %s as they become payable", next[i], d.Conf.Delegate))
 	}
 
-	payer := pay.NewPayer(ps.gt, ps.wallet, ps.conf)
-	lastCycle := head.Metadata.Level.Cycle
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
 
 	for {
-		select {
-		case <-ticker.C:
-			now, err := ps.gt.Block.GetHead()
+		reports := []pay.Report{}
+		for i, d := range ps.delegates {
+			if stopped[i] {
+				continue
+			}
+
+			payable, err := pay.PayableCycle(ps.gt, d.Conf.CycleOffset)
 			if err != nil {
 				ps.reporter.Log(err)
+				break
 			}
-			currentCycle := now.Metadata.Level.Cycle
-			if currentCycle > lastCycle {
-				ps.conf.Cycle = currentCycle
-				payouts, ops, err := payer.Payout()
+
+			for next[i] <= payable {
+				cycles := []int{next[i]}
+				if d.Conf.Merge {
+					cycles = cycles[:0]
+					for cycle := next[i]; cycle <= payable; cycle++ {
+						cycles = append(cycles, cycle)
+					}
+				}
+
+				report, ops, err := payers[i].PayoutCycles(cycles)
 				if err != nil {
-					ps.reporter.Log(err)
-					close(quit)
+					ps.reporter.Log(fmt.Sprintf("could not pay out %s at cycles %v: %v", d.Conf.Delegate, cycles, err))
+					stopped[i] = true
+					break
 				}
-				for _, op := range ops {
-					ps.reporter.Log("Successful operation: " + string(op))
-					if ps.rbot != nil {
-						err := ps.rbot.Post(string(op), currentCycle)
-						if err != nil {
-							ps.reporter.Log(fmt.Sprintf("could not post to reddit: %v", err))
-						}
-					}
+				ps.post(ops, reporting.FormatCycles(report))
 
-					if ps.tbot != nil {
-						err := ps.tbot.Post(string(op), currentCycle)
-						if err != nil {
-							ps.reporter.Log(fmt.Sprintf("could not post to twitter: %v", err))
-						}
-					}
+				if len(ps.delegates) > 1 {
+					ps.reporter.Log(fmt.Sprintf("payout for %s at cycles %v", d.Conf.Delegate, cycles))
 				}
-				ps.reporter.PrintPaymentsTable(payouts)
-				ps.reporter.WriteCSVReport(payouts)
-				lastCycle = currentCycle
+				ps.reporter.PrintPaymentsTable(report)
+				ps.reporter.WriteCSVReport(report)
+				reports = append(reports, report)
+				next[i] = cycles[len(cycles)-1] + 1
 			}
-		case <-quit:
-			ticker.Stop()
+		}
+
+		if len(reports) > 1 {
+			ps.reporter.PrintDelegatesSummaryTable(reports)
+		}
+
+		if ps.allStopped(stopped) {
 			return
 		}
+		<-ticker.C
+	}
+}
+
+// post logs the operations of a payout and posts them to the bots that are running
+func (ps *PayoutServer) post(ops [][]byte, cycles string) {
+	for _, op := range ops {
+		ps.reporter.Log("Successful operation: " + string(op))
+		if ps.rbot != nil {
+			err := ps.rbot.Post(string(op), cycles)
+			if err != nil {
+				ps.reporter.Log(fmt.Sprintf("could not post to reddit: %v", err))
+			}
+		}
+
+		if ps.tbot != nil {
+			err := ps.tbot.Post(string(op), cycles)
+			if err != nil {
+				ps.reporter.Log(fmt.Sprintf("could not post to twitter: %v", err))
+			}
+		}
+	}
+}
+
+// allStopped returns true if the payouts of every delegate have failed
+func (ps *PayoutServer) allStopped(stopped []bool) bool {
+	for _, s := range stopped {
+		if !s {
+			return false
+		}
+	}
+	return true
+}
+
+// firstCycle returns the first cycle the server should pay out: the cycle after the last one paid in the
+// ledger, the cycle passed in the conf if nothing was paid yet, or else the next cycle to become payable
+func (ps *PayoutServer) firstCycle(conf *options.Options) (int, error) {
+	if ps.ledger != nil {
+		if last := ps.ledger.LastPaid(conf.Delegate); last != -1 {
+			return last + 1, nil
+		}
+	}
+
+	if conf.Cycle != 0 {
+		return conf.Cycle, nil
+	}
+
+	payable, err := pay.PayableCycle(ps.gt, conf.CycleOffset)
+	if err != nil {
+		return 0, err
 	}
+	return payable + 1, nil
 }
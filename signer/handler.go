@@ -0,0 +1,72 @@
+package signer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// NewHandler returns a handler serving the Tezos remote signer HTTP protocol for the key of signer,
+// a local stand-in for a remote signer to test against (e.g. httptest.NewServer(signer.NewHandler(signer.NewWallet(wallet))))
+func NewHandler(signer Signer) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/keys/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.TrimPrefix(r.URL.Path, "/keys/") != signer.Address() {
+			writeError(w, http.StatusNotFound, "no key "+strings.TrimPrefix(r.URL.Path, "/keys/"))
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			publicKey, err := signer.PublicKey()
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			writeJSON(w, map[string]string{"public_key": publicKey})
+		case http.MethodPost:
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			var data string
+			if err = json.Unmarshal(body, &data); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if !strings.HasPrefix(data, watermark) {
+				writeError(w, http.StatusForbidden, "only generic operations are signed")
+				return
+			}
+
+			signature, err := signer.Sign(strings.TrimPrefix(data, watermark))
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			writeJSON(w, map[string]string{"signature": signature})
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+	mux.HandleFunc("/authorized_keys", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{})
+	})
+	return mux
+}
+
+// writeJSON writes v as the json response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error response the way tezos-signer does
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode([]map[string]string{{"kind": "generic", "error": msg}})
+}
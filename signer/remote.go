@@ -0,0 +1,104 @@
+package signer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Remote signs operations with a key held by a remote signer, such as tezos-signer, speaking the
+// Tezos remote signer HTTP protocol: GET /keys/<pkh> returns the key's public key, and POST
+// /keys/<pkh> with the watermarked bytes of an operation returns their signature
+type Remote struct {
+	url     string
+	address string
+	client  *http.Client
+}
+
+// NewRemote returns a signer for the key at uri, written as tezos-client expects remote keys
+// (e.g. http://localhost:6732/tz1...)
+func NewRemote(uri string) (*Remote, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse remote signer %s: %v", uri, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("could not parse remote signer %s: scheme must be http or https", uri)
+	}
+
+	address := path.Base(u.Path)
+	if !strings.HasPrefix(address, "tz") {
+		return nil, fmt.Errorf("could not parse remote signer %s: no key to sign with (e.g. http://localhost:6732/tz1...)", uri)
+	}
+	u.Path = strings.TrimSuffix(path.Dir(u.Path), "/")
+
+	return &Remote{url: u.String(), address: address, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// Address returns the public key hash of the remote key
+func (r *Remote) Address() string {
+	return r.address
+}
+
+// PublicKey asks the remote signer for the public key of the remote key
+func (r *Remote) PublicKey() (string, error) {
+	var resp struct {
+		PublicKey string `json:"public_key"`
+	}
+	if err := r.do(http.MethodGet, nil, &resp); err != nil {
+		return "", fmt.Errorf("could not get public key of %s: %v", r.address, err)
+	}
+	return resp.PublicKey, nil
+}
+
+// Sign asks the remote signer to sign the forged bytes of an operation with the generic operation watermark
+func (r *Remote) Sign(operation string) (string, error) {
+	body, err := json.Marshal(watermark + operation)
+	if err != nil {
+		return "", fmt.Errorf("could not sign operation with %s: %v", r.address, err)
+	}
+
+	var resp struct {
+		Signature string `json:"signature"`
+	}
+	if err = r.do(http.MethodPost, body, &resp); err != nil {
+		return "", fmt.Errorf("could not sign operation with %s: %v", r.address, err)
+	}
+	if _, err = Bytes(resp.Signature); err != nil {
+		return "", fmt.Errorf("could not sign operation with %s: %v", r.address, err)
+	}
+	return resp.Signature, nil
+}
+
+// do sends a request for the remote key to the signer and unmarshals its response into v
+func (r *Remote) do(method string, body []byte, v interface{}) error {
+	req, err := http.NewRequest(method, r.url+"/keys/"+r.address, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("remote signer returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,196 @@
+package signer
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	goTezos "github.com/DefinitelyNotAGoat/go-tezos"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/ed25519"
+)
+
+// operation is the forged bytes of an operation to sign, a branch and a transaction
+const operation = "a4d8f1d3d1b4d0a6b0f32ea8d94c6ee71e0a27c1e1e0cfb8a5a5c1e0dc4a7b9c6c00e0d7b3cd4bc7a4e6d1d8da84f0a49e5f77cd7ab2ee0b9c0b8a0ce801c0843d0000a31e81ac3425310e3274a4698a793b2839dc0afa00"
+
+func testWallet(t *testing.T) goTezos.Wallet {
+	wallet, err := (&goTezos.AccountService{}).CreateWallet("payman remote signer test", "")
+	if err != nil {
+		t.Fatalf("could not create wallet: %v", err)
+	}
+	return wallet
+}
+
+func TestRemote(t *testing.T) {
+	wallet := testWallet(t)
+	local := NewWallet(wallet)
+
+	var posted []string
+	handler := NewHandler(local)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			body, _ := ioutil.ReadAll(r.Body)
+			posted = append(posted, string(body))
+			r.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	remote, err := NewRemote(server.URL + "/" + wallet.Address)
+	if err != nil {
+		t.Fatalf("NewRemote() error: %v", err)
+	}
+	if remote.Address() != wallet.Address {
+		t.Errorf("Address() = %s, want %s", remote.Address(), wallet.Address)
+	}
+
+	publicKey, err := remote.PublicKey()
+	if err != nil {
+		t.Fatalf("PublicKey() error: %v", err)
+	}
+	if publicKey != wallet.Pk {
+		t.Errorf("PublicKey() = %s, want %s", publicKey, wallet.Pk)
+	}
+
+	signature, err := remote.Sign(operation)
+	if err != nil {
+		t.Fatalf("Sign() error: %v", err)
+	}
+
+	var body string
+	if len(posted) != 1 || json.Unmarshal([]byte(posted[0]), &body) != nil || body != "03"+operation {
+		t.Errorf("Sign() posted %q, want the json string of 03 and the operation", posted)
+	}
+
+	want, err := local.Sign(operation)
+	if err != nil {
+		t.Fatalf("Wallet.Sign() error: %v", err)
+	}
+	if signature != want {
+		t.Errorf("Sign() = %s, want %s as the wallet signs it", signature, want)
+	}
+
+	raw, err := Bytes(signature)
+	if err != nil {
+		t.Fatalf("Bytes() error: %v", err)
+	}
+	opBytes, _ := hex.DecodeString("03" + operation)
+	hash := blake2b.Sum256(opBytes)
+	if !ed25519.Verify(ed25519.PublicKey(wallet.Kp.PubKey), hash[:], raw) {
+		t.Errorf("Sign() = %s, does not verify with the wallet's public key", signature)
+	}
+}
+
+func TestRemoteErrors(t *testing.T) {
+	wallet := testWallet(t)
+
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+		// publicKey is whether the public key can still be read
+		publicKey bool
+	}{
+		{
+			name: "unknown key",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				writeError(w, http.StatusNotFound, "no key")
+			},
+			want: "404 Not Found",
+		},
+		{
+			name: "refused",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				writeError(w, http.StatusForbidden, "only generic operations are signed")
+			},
+			want: "only generic operations are signed",
+		},
+		{
+			name: "malformed response",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("not json"))
+			},
+			want: "invalid character",
+		},
+		{
+			name: "malformed signature",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]string{"public_key": wallet.Pk, "signature": "edsig1"})
+			},
+			want:      "could not decode signature",
+			publicKey: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := httptest.NewServer(c.handler)
+			defer server.Close()
+
+			remote, err := NewRemote(server.URL + "/" + wallet.Address)
+			if err != nil {
+				t.Fatalf("NewRemote() error: %v", err)
+			}
+
+			_, signErr := remote.Sign(operation)
+			if signErr == nil || !strings.Contains(signErr.Error(), c.want) {
+				t.Errorf("Sign() error = %v, want it to contain %q", signErr, c.want)
+			}
+			if _, err = remote.PublicKey(); (err == nil) != c.publicKey {
+				t.Errorf("PublicKey() error = %v, want an error %v", err, !c.publicKey)
+			}
+		})
+	}
+
+	t.Run("signer down", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		remote, err := NewRemote(server.URL + "/" + wallet.Address)
+		if err != nil {
+			t.Fatalf("NewRemote() error: %v", err)
+		}
+		server.Close()
+
+		if _, err = remote.Sign(operation); err == nil {
+			t.Errorf("Sign() error = nil, want an error")
+		}
+		if _, err = remote.PublicKey(); err == nil {
+			t.Errorf("PublicKey() error = nil, want an error")
+		}
+	})
+}
+
+func TestNewRemote(t *testing.T) {
+	cases := []struct {
+		uri     string
+		url     string
+		address string
+		err     bool
+	}{
+		{uri: "http://localhost:6732/tz1SF9wBoBQbFUF13agZ8EgihLCKM54G1ccV", url: "http://localhost:6732", address: "tz1SF9wBoBQbFUF13agZ8EgihLCKM54G1ccV"},
+		{uri: "https://signer.example.com/prefix/tz1SF9wBoBQbFUF13agZ8EgihLCKM54G1ccV", url: "https://signer.example.com/prefix", address: "tz1SF9wBoBQbFUF13agZ8EgihLCKM54G1ccV"},
+		{uri: "tcp://localhost:7732/tz1SF9wBoBQbFUF13agZ8EgihLCKM54G1ccV", err: true},
+		{uri: "http://localhost:6732", err: true},
+	}
+
+	for _, c := range cases {
+		remote, err := NewRemote(c.uri)
+		if c.err {
+			if err == nil {
+				t.Errorf("NewRemote(%s) error = nil, want an error", c.uri)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewRemote(%s) error: %v", c.uri, err)
+			continue
+		}
+		if remote.url != c.url || remote.address != c.address {
+			t.Errorf("NewRemote(%s) = %s %s, want %s %s", c.uri, remote.url, remote.address, c.url, c.address)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package signer
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/Messer4/base58check"
+)
+
+var (
+	// prefixes of the base58check encodings of signatures
+	edsig  = []byte{9, 245, 205, 134, 18}
+	spsig1 = []byte{13, 115, 101, 19, 63}
+	p2sig  = []byte{54, 240, 44, 52}
+	sig    = []byte{4, 130, 43}
+)
+
+// watermark is the magic byte signers prepend to the bytes of a generic operation before signing them
+const watermark = "03"
+
+// signatureSize is the size of a signature of any curve
+const signatureSize = 64
+
+// Signer signs operations for the wallet paying out, so its secret key can be kept in process or
+// behind a remote signer
+type Signer interface {
+	// Address returns the public key hash of the wallet
+	Address() string
+	// PublicKey returns the public key of the wallet
+	PublicKey() (string, error)
+	// Sign signs the forged bytes of an operation, in hex, and returns the encoded signature
+	Sign(operation string) (string, error)
+}
+
+// Bytes returns the raw bytes of an encoded signature of any curve, to append to the forged operation it signs
+func Bytes(signature string) ([]byte, error) {
+	data, err := base58check.Decode(signature)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode signature %s: %v", signature, err)
+	}
+
+	for _, prefix := range [][]byte{edsig, spsig1, p2sig, sig} {
+		if bytes.HasPrefix(data, prefix) && len(data) == len(prefix)+signatureSize {
+			return data[len(prefix):], nil
+		}
+	}
+	return nil, fmt.Errorf("could not decode signature %s: not a signature", signature)
+}
+
+// Empty returns an encoded signature of zero bytes, for simulating an operation before it is signed
+func Empty() string {
+	return base58check.Encode(append(append([]byte{}, edsig...), make([]byte, signatureSize)...))
+}
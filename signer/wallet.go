@@ -0,0 +1,47 @@
+package signer
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	goTezos "github.com/DefinitelyNotAGoat/go-tezos"
+	"github.com/Messer4/base58check"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Wallet signs operations in process with the decrypted secret key of a wallet
+type Wallet struct {
+	wallet goTezos.Wallet
+}
+
+// NewWallet returns a signer for the imported wallet
+func NewWallet(wallet goTezos.Wallet) *Wallet {
+	return &Wallet{wallet: wallet}
+}
+
+// Address returns the public key hash of the wallet
+func (w *Wallet) Address() string {
+	return w.wallet.Address
+}
+
+// PublicKey returns the public key of the wallet
+func (w *Wallet) PublicKey() (string, error) {
+	return w.wallet.Pk, nil
+}
+
+// Sign signs the forged bytes of an operation the same way tezos-client does, hashing them with
+// the generic operation watermark and signing the hash with the wallet's ed25519 key
+func (w *Wallet) Sign(operation string) (string, error) {
+	opBytes, err := hex.DecodeString(watermark + operation)
+	if err != nil {
+		return "", fmt.Errorf("could not sign operation: %v", err)
+	}
+	if len(w.wallet.Kp.PrivKey) != ed25519.PrivateKeySize {
+		return "", fmt.Errorf("could not sign operation: wallet %s has no secret key", w.wallet.Address)
+	}
+
+	hash := blake2b.Sum256(opBytes)
+	signature := ed25519.Sign(w.wallet.Kp.PrivKey, hash[:])
+	return base58check.Encode(append(append([]byte{}, edsig...), signature...)), nil
+}
@@ -0,0 +1,235 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	goTezos "github.com/DefinitelyNotAGoat/go-tezos"
+)
+
+// pollInterval is how often the tracker checks the head of the chain for new blocks
+const pollInterval = 30 * time.Second
+
+// defaultTTL is the number of blocks an operation may wait to be included if the node does not report max_operations_ttl
+const defaultTTL = 60
+
+// Status describes the state of a tracked operation on chain
+type Status string
+
+const (
+	// StatusPending is an operation that is not included yet or does not have enough confirmations
+	StatusPending Status = "pending"
+	// StatusConfirmed is an operation that was applied and has the required number of confirmations
+	StatusConfirmed Status = "confirmed"
+	// StatusFailed is an operation that was included in a block but was not applied
+	StatusFailed Status = "failed"
+	// StatusDropped is an operation that was not included before its branch expired
+	StatusDropped Status = "dropped"
+)
+
+// Result is the state of a tracked operation on chain
+type Result struct {
+	OpHash        string
+	Level         int
+	Confirmations int
+	Status        Status
+	Error         string
+}
+
+// Tracker polls the chain until injected operations are included and confirmed
+type Tracker struct {
+	gt            *goTezos.GoTezos
+	confirmations int
+}
+
+// operationResult is the subset of an operation in a block needed to know if it was applied
+type operationResult struct {
+	Hash     string `json:"hash"`
+	Contents []struct {
+		Metadata struct {
+			OperationResult struct {
+				Status string          `json:"status"`
+				Errors json.RawMessage `json:"errors"`
+			} `json:"operation_result"`
+		} `json:"metadata"`
+	} `json:"contents"`
+}
+
+// NewTracker returns a new Tracker requiring confirmations blocks on top of an operation's block
+func NewTracker(gt *goTezos.GoTezos, confirmations int) *Tracker {
+	if confirmations < 1 {
+		confirmations = 1
+	}
+	return &Tracker{gt: gt, confirmations: confirmations}
+}
+
+// Wait polls blocks starting after level from until every operation in opHashes is confirmed,
+// failed or dropped, and returns the result of each operation keyed by its hash
+func (t *Tracker) Wait(opHashes []string, from int) (map[string]*Result, error) {
+	results := newResults(opHashes)
+	scanned := from
+	for {
+		done, err := t.poll(results, from, &scanned)
+		if err != nil || done {
+			return results, err
+		}
+
+		time.Sleep(pollInterval)
+	}
+}
+
+// Check scans the blocks after level from up to the head once, without waiting, and returns the
+// result of each operation in opHashes. Operations that were not included yet are pending until
+// the operations TTL of level from has passed, and dropped after.
+func (t *Tracker) Check(opHashes []string, from int) (map[string]*Result, error) {
+	results := newResults(opHashes)
+	scanned := from
+	_, err := t.poll(results, from, &scanned)
+	return results, err
+}
+
+// newResults returns a pending result for each operation in opHashes, keyed by its hash
+func newResults(opHashes []string) map[string]*Result {
+	results := make(map[string]*Result)
+	for _, opHash := range opHashes {
+		results[opHash] = &Result{OpHash: opHash, Status: StatusPending}
+	}
+	return results
+}
+
+// poll scans the blocks after level scanned up to the head, and updates the status of every pending
+// result. It returns true once no result is pending.
+func (t *Tracker) poll(results map[string]*Result, from int, scanned *int) (bool, error) {
+	head, err := t.gt.Block.GetHead()
+	if err != nil {
+		return false, fmt.Errorf("could not track operations: %v", err)
+	}
+
+	ttl := head.Metadata.MaxOperationsTTL
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+
+	// no operation can be included after its branch expires, so there is nothing to find past it
+	for level := *scanned + 1; level <= head.Header.Level && level <= from+ttl+1; level++ {
+		if err = t.scan(level, results); err != nil {
+			return false, fmt.Errorf("could not track operations: %v", err)
+		}
+		*scanned = level
+	}
+
+	done := true
+	for _, result := range results {
+		if result.Status != StatusPending {
+			continue
+		}
+
+		if result.Level == 0 {
+			if head.Header.Level-from > ttl {
+				result.Status = StatusDropped
+				result.Error = fmt.Sprintf("not included within %d blocks of level %d", ttl, from)
+				continue
+			}
+			done = false
+			continue
+		}
+
+		result.Confirmations = head.Header.Level - result.Level + 1
+		if result.Confirmations >= t.confirmations {
+			// the block the operation was found in may have been replaced by a reorganisation since
+			included, err := t.included(result.OpHash, result.Level)
+			if err != nil {
+				return false, fmt.Errorf("could not track operations: %v", err)
+			}
+			if included {
+				result.Status = StatusConfirmed
+				continue
+			}
+
+			// scan again from where it was, in case it was included again in the new blocks
+			if *scanned >= result.Level {
+				*scanned = result.Level - 1
+			}
+			result.Level = 0
+			result.Confirmations = 0
+		}
+		done = false
+	}
+
+	return done, nil
+}
+
+// included returns true if the operation is still in the block at level on the main chain
+func (t *Tracker) included(opHash string, level int) (bool, error) {
+	hashes, err := t.gt.Operation.GetBlockOperationHashes(level)
+	if err != nil {
+		return false, err
+	}
+
+	for _, hash := range hashes {
+		if hash == opHash {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// scan looks for pending operations in the block at level and records where they were included
+func (t *Tracker) scan(level int, results map[string]*Result) error {
+	hashes, err := t.gt.Operation.GetBlockOperationHashes(level)
+	if err != nil {
+		return err
+	}
+
+	var found bool
+	for _, hash := range hashes {
+		if result, ok := results[hash]; ok && result.Level == 0 {
+			result.Level = level
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	return t.checkApplied(level, results)
+}
+
+// checkApplied marks operations included at level as failed if the node did not apply them
+func (t *Tracker) checkApplied(level int, results map[string]*Result) error {
+	block, err := t.gt.Block.Get(level)
+	if err != nil {
+		return err
+	}
+
+	// manager operations (transactions, reveals) are in the fourth validation pass
+	resp, err := t.gt.Get("/chains/main/blocks/"+block.Hash+"/operations/3", nil)
+	if err != nil {
+		return err
+	}
+
+	var operations []operationResult
+	if err = json.Unmarshal(resp, &operations); err != nil {
+		return fmt.Errorf("could not unmarshal operations at level %d: %v", level, err)
+	}
+
+	for _, op := range operations {
+		result, ok := results[op.Hash]
+		if !ok || result.Level != level {
+			continue
+		}
+
+		for _, content := range op.Contents {
+			status := content.Metadata.OperationResult.Status
+			if status != "" && status != "applied" {
+				result.Status = StatusFailed
+				result.Error = strings.TrimSpace(fmt.Sprintf("%s %s", status, string(content.Metadata.OperationResult.Errors)))
+				break
+			}
+		}
+	}
+
+	return nil
+}